@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+func statPath(path string) (fs.FileInfo, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: not found", path)
+	} else if errors.Is(err, fs.ErrPermission) {
+		return nil, fmt.Errorf("%s: permission denied", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return info, nil
+}
+
+// FileExists is an Assert that passes for paths to existing files other
+// than directories
+func FileExists(path string) error {
+	info, err := statPath(path)
+	if err != nil {
+		return err
+	} else if info.IsDir() {
+		return fmt.Errorf("%s: is a directory", path)
+	}
+	return nil
+}
+
+// DirExists is an Assert that passes for paths to existing directories
+func DirExists(path string) error {
+	info, err := statPath(path)
+	if err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", path)
+	}
+	return nil
+}
+
+// Readable is an Assert that passes for paths that can be opened for
+// reading
+func Readable(path string) error {
+	if _, err := statPath(path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrPermission) {
+		return fmt.Errorf("%s: permission denied", path)
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
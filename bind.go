@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type binding struct {
+	name  string
+	field reflect.Value
+}
+
+// Bind registers an option for every struct field tagged with `arg` and
+// fills the fields in after parsing. `arg:"--name,-n"` registers a keyword
+// and `arg:"name"` a positional arg. The `help` and `default` tags set Help
+// and Default, and `required:"true"` sets Required. Supported field types
+// are string, int, bool and []string; defaults for []string are comma
+// separated. Fields are set from Parsed, so they hold defaults, env values
+// and Map results too
+func (parser *Parser) Bind(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w\nreason: expected a pointer to a struct, got %T\n", ErrInvalidBind, v)
+	}
+
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("arg")
+		if !ok {
+			continue
+		}
+
+		short, long, positional := bindNames(tag)
+		opts := &Option{
+			Help:     field.Tag.Get("help"),
+			Required: field.Tag.Get("required") == "true",
+		}
+		def, hasDefault := field.Tag.Lookup("default")
+
+		switch field.Type.Kind() {
+		case reflect.String:
+			opts.N = 1
+			opts.AllowEmpty = true
+			if hasDefault {
+				opts.Default = []string{def}
+			}
+		case reflect.Int:
+			opts.N = 1
+			opts.Type = TypeInt
+			if hasDefault {
+				opts.Default = []string{def}
+			}
+		case reflect.Bool:
+			opts.Flag = true
+			if positional != "" {
+				return fmt.Errorf("%w\nfield: %s\nreason: bool fields must be keywords\n", ErrInvalidBind, field.Name)
+			} else if b, err := strconv.ParseBool(def); hasDefault && err == nil && b {
+				opts.Default = []string{}
+			}
+		case reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.String {
+				return fmt.Errorf("%w\nfield: %s\nreason: unsupported type %s\n", ErrInvalidBind, field.Name, field.Type)
+			} else if positional != "" {
+				return fmt.Errorf("%w\nfield: %s\nreason: []string fields must be keywords\n", ErrInvalidBind, field.Name)
+			}
+			opts.Nargs = "+"
+			opts.AllowDuplicates = true
+			if hasDefault {
+				opts.Default = strings.Split(def, ",")
+			}
+		default:
+			return fmt.Errorf("%w\nfield: %s\nreason: unsupported type %s\n", ErrInvalidBind, field.Name, field.Type)
+		}
+
+		if positional != "" {
+			opts.N = 0
+			parser.Argument(positional, opts)
+		} else if short != "" || long != "" {
+			parser.Keyword(short, long, opts)
+		} else {
+			return fmt.Errorf("%w\nfield: %s\n", ErrMissingName, field.Name)
+		}
+
+		parser.bindings = append(parser.bindings, &binding{
+			name:  opts.Name,
+			field: rv.Field(i),
+		})
+	}
+
+	return nil
+}
+
+// bindNames splits an `arg` tag into the short, long and positional names
+func bindNames(tag string) (short, long, positional string) {
+	for _, name := range strings.Split(tag, ",") {
+		name = strings.TrimSpace(name)
+		if strings.HasPrefix(name, "--") {
+			long = name[2:]
+		} else if strings.HasPrefix(name, "-") {
+			short = name[1:]
+		} else {
+			positional = name
+		}
+	}
+	return short, long, positional
+}
+
+// populate sets the bound struct fields from the parsed values
+func (parser *Parser) populate() {
+	for _, x := range parser.bindings {
+		if parser.offMap[x.name] && x.field.Kind() == reflect.Bool {
+			x.field.SetBool(false)
+			continue
+		} else if _, ok := parser.Parsed[x.name]; !ok {
+			continue
+		}
+
+		var err error
+		switch x.field.Kind() {
+		case reflect.String:
+			var s string
+			s, err = parser.GetString(x.name)
+			x.field.SetString(s)
+		case reflect.Int:
+			var n int
+			n, err = parser.GetInt(x.name)
+			x.field.SetInt(int64(n))
+		case reflect.Bool:
+			var b bool
+			b, err = parser.GetBool(x.name)
+			x.field.SetBool(b)
+		case reflect.Slice:
+			x.field.Set(reflect.ValueOf(parser.Parsed[x.name]))
+		}
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Unbind turns a struct laid out for Bind back into args that parse to the
+// same values, for forwarding a normalized command line. Keywords come
+// first as --name=value, or -n=value without a long name, and are skipped
+// when they hold their default, or the zero value without one. []string
+// fields repeat the switch once per value, and a false bool is written as
+// --name=false when its default is true. Positional args
+// follow a `--` so that values starting with - are read literally
+func (parser *Parser) Unbind(v interface{}) ([]string, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w\nreason: expected a struct, got %T\n", ErrInvalidBind, v)
+	}
+
+	rt := rv.Type()
+	res := []string{}
+	positionals := []string{}
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag, ok := field.Tag.Lookup("arg")
+		if !ok {
+			continue
+		}
+
+		short, long, positional := bindNames(tag)
+		def, hasDefault := field.Tag.Lookup("default")
+		value := rv.Field(i)
+		values := []string{}
+		unchanged := false
+
+		switch value.Kind() {
+		case reflect.String:
+			values = append(values, value.String())
+			unchanged = value.String() == def
+		case reflect.Int:
+			values = append(values, strconv.FormatInt(value.Int(), 10))
+			n, err := strconv.ParseInt(def, 10, 64)
+			unchanged = value.Int() == n && (err == nil || !hasDefault)
+		case reflect.Bool:
+			b, _ := strconv.ParseBool(def)
+			values = append(values, strconv.FormatBool(value.Bool()))
+			unchanged = value.Bool() == b
+		case reflect.Slice:
+			if field.Type.Elem().Kind() != reflect.String {
+				return nil, fmt.Errorf("%w\nfield: %s\nreason: unsupported type %s\n", ErrInvalidBind, field.Name, field.Type)
+			}
+			values = append(values, value.Interface().([]string)...)
+			unchanged = len(values) == 0 && !hasDefault
+			if hasDefault {
+				unchanged = slices.Equal(values, strings.Split(def, ","))
+			}
+		default:
+			return nil, fmt.Errorf("%w\nfield: %s\nreason: unsupported type %s\n", ErrInvalidBind, field.Name, field.Type)
+		}
+
+		if positional != "" {
+			positionals = append(positionals, values...)
+			continue
+		} else if short == "" && long == "" {
+			return nil, fmt.Errorf("%w\nfield: %s\n", ErrMissingName, field.Name)
+		} else if unchanged {
+			continue
+		} else if len(values) == 0 {
+			return nil, fmt.Errorf("%w\nfield: %s\nreason: an empty list cannot override the default\n", ErrInvalidBind, field.Name)
+		}
+
+		name := "--" + long
+		if long == "" {
+			name = "-" + short
+		}
+
+		for _, x := range values {
+			if value.Kind() == reflect.Bool && x == "true" {
+				res = append(res, name)
+			} else {
+				res = append(res, name+"="+x)
+			}
+		}
+	}
+
+	if len(positionals) > 0 {
+		res = append(res, "--")
+		res = append(res, positionals...)
+	}
+
+	return res, nil
+}
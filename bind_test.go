@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+type unbindOptions struct {
+	Name    string   `arg:"--name" default:"bob"`
+	Count   int      `arg:"-c" default:"3"`
+	Level   int      `arg:"--level"`
+	Verbose bool     `arg:"-v" default:"true"`
+	Quiet   bool     `arg:"--quiet,-q"`
+	Files   []string `arg:"--files" default:"a,b"`
+	Input   string   `arg:"input"`
+}
+
+func TestUnbindRoundTrip(t *testing.T) {
+	for _, opts := range []unbindOptions{
+		{Name: "", Count: 0, Verbose: false, Files: []string{"x"}, Input: "-in"},
+		{Name: "bob", Count: 3, Level: 2, Verbose: true, Quiet: true, Files: []string{"a", "b"}, Input: "in"},
+		{Name: "alice", Count: -1, Files: []string{"a,b", "c"}, Input: ""},
+	} {
+		argv, err := New([]string{}).Unbind(opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+
+		var got unbindOptions
+		parser := New(argv)
+		if err := parser.Bind(&got); err != nil {
+			t.Fatal(err)
+		}
+		mustParse(t, parser)
+
+		if !reflect.DeepEqual(got, opts) {
+			t.Errorf("argv %q: got %+v, want %+v", argv, got, opts)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// completionShells are the shells accepted by the completion command
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// AddCompletionCommand registers a hidden completion subcommand that
+// prints the completion script for the shell passed to it and then exits
+// like help does
+func (parser *Parser) AddCompletionCommand() *Parser {
+	child := parser.Subcommand("completion", &Option{
+		Hidden: true,
+		Help:   "print a shell completion script",
+	})
+	child.Argument("shell", &Option{
+		Enum: completionShells,
+		Help: "shell to generate the script for",
+	})
+	parser.completionName = "completion"
+
+	return parser
+}
+
+// completion returns the completion script for shell
+func (parser *Parser) completion(shell string) string {
+	switch shell {
+	case "bash":
+		return parser.GenBashCompletion()
+	case "zsh":
+		return parser.GenZshCompletion()
+	case "fish":
+		return parser.GenFishCompletion()
+	case "powershell":
+		return parser.GenPowerShellCompletion()
+	}
+	return ""
+}
+
+// completionFunc returns the name of the shell function completing cmd
+func completionFunc(cmd string) string {
+	return "_" + strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, cmd)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// GenBashCompletion returns a bash completion script that completes switch
+// names and, after a switch with Enum set, its values
+func (parser *Parser) GenBashCompletion() string {
+	res := strings.Builder{}
+	cmd := parser.prog()
+	fn := completionFunc(cmd)
+
+	fmt.Fprintf(&res, "%s() {\n", fn)
+	res.WriteString("  local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	res.WriteString("  local prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	res.WriteString("  case \"$prev\" in\n")
+
+	names := []string{}
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.Hidden {
+			continue
+		}
+
+		switches := []string{}
+		if opts.ShortName != "" {
+			names = append(names, "-"+opts.ShortName)
+			switches = append(switches, shellQuote("-"+opts.ShortName))
+		}
+		if opts.LongName != "" {
+			names = append(names, "--"+opts.LongName)
+			switches = append(switches, shellQuote("--"+opts.LongName))
+		}
+
+		if opts.Enum == nil || len(switches) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&res, "    %s)\n", strings.Join(switches, "|"))
+		fmt.Fprintf(&res, "      COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(opts.Enum, " ")))
+		res.WriteString("      return\n")
+		res.WriteString("      ;;\n")
+	}
+
+	res.WriteString("  esac\n")
+	fmt.Fprintf(&res, "  COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(names, " ")))
+	res.WriteString("}\n")
+	fmt.Fprintf(&res, "complete -F %s %s\n", fn, shellQuote(cmd))
+
+	return res.String()
+}
+
+// zshEscape escapes the characters that are special in the brackets and
+// fields of an _arguments spec
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// GenZshCompletion returns a zsh completion script passing one _arguments
+// spec per switch name, with the Enum values of switches that take values
+func (parser *Parser) GenZshCompletion() string {
+	res := strings.Builder{}
+	cmd := parser.prog()
+	fn := completionFunc(cmd)
+
+	fmt.Fprintf(&res, "#compdef %s\n\n", cmd)
+	fmt.Fprintf(&res, "%s() {\n", fn)
+	res.WriteString("  _arguments")
+
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.Hidden {
+			continue
+		}
+
+		spec := ""
+		if opts.Help != "" {
+			spec += "[" + zshEscape(opts.Help) + "]"
+		}
+		if opts.N != 0 || opts.Nargs != "" {
+			action := "_default"
+			if opts.Enum != nil {
+				enum := []string{}
+				for _, v := range opts.Enum {
+					enum = append(enum, zshEscape(v))
+				}
+				action = "(" + strings.Join(enum, " ") + ")"
+			}
+			spec += ":" + zshEscape(opts.Name) + ":" + action
+		}
+
+		if opts.ShortName != "" {
+			fmt.Fprintf(&res, " \\\n    %s", shellQuote("-"+opts.ShortName+spec))
+		}
+		if opts.LongName != "" {
+			fmt.Fprintf(&res, " \\\n    %s", shellQuote("--"+opts.LongName+spec))
+		}
+	}
+
+	res.WriteString("\n}\n\n")
+	fmt.Fprintf(&res, "if [ \"$funcstack[1]\" = %s ]; then\n", shellQuote(fn))
+	fmt.Fprintf(&res, "  %s \"$@\"\n", fn)
+	res.WriteString("else\n")
+	fmt.Fprintf(&res, "  compdef %s %s\n", fn, shellQuote(cmd))
+	res.WriteString("fi\n")
+
+	return res.String()
+}
+
+func fishQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `'`, `\'`)
+	return "'" + s + "'"
+}
+
+// GenFishCompletion returns a fish completion script with one `complete`
+// line per keyword
+func (parser *Parser) GenFishCompletion() string {
+	res := strings.Builder{}
+	cmd := parser.prog()
+
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.Hidden {
+			continue
+		}
+
+		line := []string{"complete", "-c", fishQuote(cmd)}
+
+		if opts.ShortName != "" {
+			line = append(line, "-s", fishQuote(opts.ShortName))
+		}
+
+		if opts.LongName != "" {
+			line = append(line, "-l", fishQuote(opts.LongName))
+		}
+
+		if opts.Help != "" {
+			line = append(line, "-d", fishQuote(opts.Help))
+		}
+
+		if opts.N == 0 && opts.Nargs == "" {
+			line = append(line, "-f")
+		} else if opts.Enum != nil {
+			line = append(line, "-x", "-a", fishQuote(strings.Join(opts.Enum, " ")))
+		} else {
+			line = append(line, "-r")
+		}
+
+		res.WriteString(strings.Join(line, " "))
+		res.WriteString("\n")
+	}
+
+	return res.String()
+}
+
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// GenPowerShellCompletion returns a Register-ArgumentCompleter script that
+// completes switch names and, after a switch with Enum set, its values
+func (parser *Parser) GenPowerShellCompletion() string {
+	res := strings.Builder{}
+	cmd := parser.prog()
+	result := "    [System.Management.Automation.CompletionResult]::new(%s, %s, '%s', %s)\n"
+
+	fmt.Fprintf(&res, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", powerShellQuote(cmd))
+	res.WriteString("  param($wordToComplete, $commandAst, $cursorPosition)\n")
+	res.WriteString("  $prev = $commandAst.CommandElements |\n")
+	res.WriteString("    Where-Object { $_.Extent.EndOffset -lt $cursorPosition } |\n")
+	res.WriteString("    Select-Object -Last 1\n")
+	res.WriteString("  $completions = switch ([string]$prev) {\n")
+
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.Hidden || opts.Enum == nil {
+			continue
+		}
+
+		names := []string{}
+		if opts.ShortName != "" {
+			names = append(names, powerShellQuote("-"+opts.ShortName))
+		}
+		if opts.LongName != "" {
+			names = append(names, powerShellQuote("--"+opts.LongName))
+		}
+
+		fmt.Fprintf(&res, "    { $_ -in %s } {\n", strings.Join(names, ", "))
+		for _, v := range opts.Enum {
+			fmt.Fprintf(&res, "  "+result, powerShellQuote(v), powerShellQuote(v), "ParameterValue", powerShellQuote(v))
+		}
+		res.WriteString("      break\n")
+		res.WriteString("    }\n")
+	}
+
+	res.WriteString("    default {\n")
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.Hidden {
+			continue
+		}
+
+		tip := opts.Help
+		if tip == "" {
+			tip = opts.Name
+		}
+
+		if opts.ShortName != "" {
+			name := powerShellQuote("-" + opts.ShortName)
+			fmt.Fprintf(&res, "  "+result, name, name, "ParameterName", powerShellQuote(tip))
+		}
+		if opts.LongName != "" {
+			name := powerShellQuote("--" + opts.LongName)
+			fmt.Fprintf(&res, "  "+result, name, name, "ParameterName", powerShellQuote(tip))
+		}
+	}
+	res.WriteString("    }\n")
+	res.WriteString("  }\n")
+	res.WriteString("  $completions | Where-Object { $_.CompletionText -like \"$wordToComplete*\" }\n")
+	res.WriteString("}\n")
+
+	return res.String()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompletionCommand(t *testing.T) {
+	out := &bytes.Buffer{}
+	parser := New([]string{"completion", "fish"})
+	parser.Prog = "tool"
+	parser.Output = out
+	parser.Keyword("", "config", &Option{N: 1, Required: true})
+	parser.AddCompletionCommand()
+
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrHelpRequested) {
+		t.Fatalf("got %v, want ErrHelpRequested", err)
+	}
+	if !strings.Contains(out.String(), "complete -c 'tool' -l 'config'") {
+		t.Errorf("got script %q", out.String())
+	}
+}
+
+func TestCompletionCommandHidden(t *testing.T) {
+	parser := New([]string{})
+	parser.Prog = "tool"
+	parser.AddCompletionCommand()
+
+	help := &bytes.Buffer{}
+	parser.WriteHelp(help)
+	if strings.Contains(help.String(), "COMMAND") || strings.Contains(help.String(), "Commands:") {
+		t.Errorf("hidden completion command shown in help:\n%s", help.String())
+	}
+}
+
+func TestCompletionShells(t *testing.T) {
+	for _, tc := range []struct {
+		shell string
+		want  []string
+	}{
+		{"bash", []string{"complete -F _tool 'tool'", "'-f'|'--format')", "compgen -W 'json xml'"}},
+		{"zsh", []string{"#compdef tool", "'--format[output format]:format:(json xml)'", "compdef _tool 'tool'"}},
+		{"powershell", []string{"Register-ArgumentCompleter -Native -CommandName 'tool'"}},
+	} {
+		out := &bytes.Buffer{}
+		parser := New([]string{"completion", tc.shell})
+		parser.Prog = "tool"
+		parser.Output = out
+		parser.Keyword("f", "format", &Option{N: 1, Enum: []string{"json", "xml"}, Help: "output format"})
+		parser.AddCompletionCommand()
+
+		if _, err := parser.ParseErr(); !errors.Is(err, ErrHelpRequested) {
+			t.Fatalf("%s: got %v, want ErrHelpRequested", tc.shell, err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: %q not in script:\n%s", tc.shell, want, out.String())
+			}
+		}
+	}
+}
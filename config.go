@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"slices"
+	"strconv"
+)
+
+// ValueSource provides values for options not passed on the command line,
+// e.g. from a config file. Lookup gets the option's name. An empty slice
+// passes a switch without values
+type ValueSource interface {
+	Lookup(name string) ([]string, bool)
+}
+
+// AddSource adds a source of values. Options not passed on the command line
+// are looked up in each source in the order they were added and lastly fall
+// back to Default. The env vars are a source too, added first by New, and
+// adding EnvSource again moves them to that point in the order
+func (parser *Parser) AddSource(source ValueSource) *Parser {
+	if _, ok := source.(envSource); ok {
+		parser.sources = slices.DeleteFunc(parser.sources, func(x ValueSource) bool {
+			_, ok := x.(envSource)
+			return ok
+		})
+	}
+	parser.sources = append(parser.sources, source)
+	return parser
+}
+
+// envSource reads the EnvVar of keywords, or the one derived from EnvPrefix
+type envSource struct {
+	parser *Parser
+}
+
+func (source envSource) Lookup(name string) ([]string, bool) {
+	x, ok := source.parser.keywordsMap[name]
+	if !ok {
+		return nil, false
+	}
+	return source.parser.envValue(x)
+}
+
+// EnvSource returns the source of env var values, so that passing it to
+// AddSource after a config file makes the file take precedence
+func (parser *Parser) EnvSource() ValueSource {
+	return envSource{parser}
+}
+
+// lookup returns a copy of the values of name from the first source that
+// has it
+func (parser *Parser) lookup(name string) ([]string, bool) {
+	for _, source := range parser.sources {
+		if values, ok := source.Lookup(name); ok {
+			return slices.Clone(values), true
+		}
+	}
+	return nil, false
+}
+
+// configSource holds the values read from a config file
+type configSource map[string][]string
+
+func (source configSource) Lookup(name string) ([]string, bool) {
+	values, ok := source[name]
+	return values, ok
+}
+
+// LoadConfigTOML adds the keyword values in a TOML file as a source. Top
+// level keys are keyword names and tables are the values for the
+// subcommand of that name. Switches without values are passed when set to
+// true
+func (parser *Parser) LoadConfigTOML(path string) error {
+	table := map[string]interface{}{}
+	if _, err := toml.DecodeFile(path, &table); err != nil {
+		return fmt.Errorf("%w\npath: %s\nreason: %v\n", ErrConfig, path, err)
+	}
+
+	return parser.loadConfig(path, table)
+}
+
+func (parser *Parser) loadConfig(path string, table map[string]interface{}) error {
+	source := configSource{}
+	for key, value := range table {
+		if sub, ok := value.(map[string]interface{}); ok {
+			i := slices.IndexFunc(parser.subcommands, func(x *subcommand) bool {
+				return x.name == key
+			})
+			if i == -1 {
+				return fmt.Errorf("%w\npath: %s\nreason: unknown subcommand %s\n", ErrConfig, path, key)
+			} else if err := parser.subcommands[i].parser.loadConfig(path, sub); err != nil {
+				return err
+			}
+			continue
+		}
+
+		x, ok := parser.keywordsMap[key]
+		if !ok {
+			return fmt.Errorf("%w\npath: %s\nreason: unknown keyword %s\n", ErrConfig, path, key)
+		}
+
+		values, err := configValues(value)
+		if err != nil {
+			return fmt.Errorf("%w\npath: %s\nkeyword: %s\nreason: %v\n", ErrConfig, path, key, err)
+		}
+
+		opts := x.opts
+		if opts.N == 0 && opts.Nargs == "" && !opts.Negatable && !opts.Count {
+			if len(values) != 1 || values[0] != "true" {
+				continue
+			}
+			values = []string{}
+		}
+		source[key] = values
+	}
+
+	parser.AddSource(source)
+	return nil
+}
+
+// configValues turns a config value into option values. Arrays give one
+// value per element
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		res := []string{}
+		for _, x := range v {
+			values, err := configValues(x)
+			if err != nil {
+				return nil, err
+			} else if len(values) != 1 {
+				return nil, fmt.Errorf("nested arrays are not supported")
+			}
+			res = append(res, values...)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v", value)
+	}
+}
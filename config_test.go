@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigArity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	config := "name = [\"a\", \"b\"]\nfiles = [\"x\", \"y\"]\n"
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser := New([]string{"--name", "c"})
+	parser.Keyword("", "name", &Option{N: 1})
+	parser.Keyword("", "files", &Option{Nargs: "+"})
+	if err := parser.LoadConfigTOML(path); err != nil {
+		t.Fatal(err)
+	}
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "name", "c")
+	expectValues(t, parsed, "files", "x", "y")
+
+	parser = New([]string{})
+	parser.Keyword("", "name", &Option{N: 1})
+	parser.Keyword("", "files", &Option{Nargs: "+"})
+	if err := parser.LoadConfigTOML(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrExcessArgs) {
+		t.Errorf("got %v, want ErrExcessArgs", err)
+	}
+}
+
+func TestEnvArity(t *testing.T) {
+	t.Setenv("TEST_SIZE", "10")
+	parser := New([]string{})
+	parser.Keyword("", "size", &Option{N: 2, EnvVar: "TEST_SIZE"})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrLessArgs) {
+		t.Errorf("got %v, want ErrLessArgs", err)
+	}
+}
+
+func TestEnvSourceOrder(t *testing.T) {
+	t.Setenv("TEST_NAME", "env")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("name = \"config\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parser := New([]string{})
+	parser.Keyword("", "name", &Option{N: 1, EnvVar: "TEST_NAME"})
+	if err := parser.LoadConfigTOML(path); err != nil {
+		t.Fatal(err)
+	}
+	expectValues(t, mustParse(t, parser.Clone()), "name", "env")
+
+	parser.AddSource(parser.EnvSource())
+	expectValues(t, mustParse(t, parser.Clone()), "name", "config")
+}
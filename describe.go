@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+type optionDescription struct {
+	Name     string   `json:"name"`
+	Short    string   `json:"short,omitempty"`
+	Long     string   `json:"long,omitempty"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Nargs    string   `json:"nargs,omitempty"`
+	N        int      `json:"n"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Enum     []string `json:"enum,omitempty"`
+	Help     string   `json:"help,omitempty"`
+	Metavar  string   `json:"metavar,omitempty"`
+	Requires []string `json:"requires,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+	Default  []string `json:"default"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Group    string   `json:"group,omitempty"`
+	Hidden   bool     `json:"hidden,omitempty"`
+}
+
+type commandDescription struct {
+	Name        string                `json:"name"`
+	Summary     string                `json:"summary,omitempty"`
+	Help        string                `json:"help,omitempty"`
+	Version     string                `json:"version,omitempty"`
+	Arguments   []*optionDescription  `json:"arguments"`
+	Keywords    []*optionDescription  `json:"keywords"`
+	Subcommands []*commandDescription `json:"subcommands"`
+}
+
+func describeOption(opts *Option) *optionDescription {
+	return &optionDescription{
+		Name:     opts.Name,
+		Short:    opts.ShortName,
+		Long:     opts.LongName,
+		Aliases:  opts.Aliases,
+		Nargs:    opts.Nargs,
+		N:        opts.N,
+		Type:     opts.Type.String(),
+		Required: opts.Required,
+		Enum:     opts.Enum,
+		Help:     opts.Help,
+		Metavar:  opts.Metavar,
+		Requires: opts.Requires,
+		Excludes: opts.Excludes,
+		Default:  opts.Default,
+		Pattern:  opts.Pattern,
+		Group:    opts.Group,
+		Hidden:   opts.Hidden,
+	}
+}
+
+func (parser *Parser) describe(name string) *commandDescription {
+	res := &commandDescription{
+		Name:        name,
+		Summary:     parser.Summary,
+		Help:        parser.Help,
+		Version:     parser.version,
+		Arguments:   []*optionDescription{},
+		Keywords:    []*optionDescription{},
+		Subcommands: []*commandDescription{},
+	}
+
+	for _, x := range parser.argumentsSlice {
+		res.Arguments = append(res.Arguments, describeOption(x.opts))
+	}
+
+	for _, x := range parser.keywordsOrder {
+		res.Keywords = append(res.Keywords, describeOption(x.opts))
+	}
+
+	for _, x := range parser.subcommands {
+		sub := x.parser.describe(x.name)
+		if sub.Help == "" {
+			sub.Help = x.opts.Help
+		}
+		res.Subcommands = append(res.Subcommands, sub)
+	}
+
+	return res
+}
+
+// Describe returns a JSON description of the command, for generating docs
+// or completions elsewhere. The command has name, summary, help, version,
+// arguments, keywords and subcommands, the last three being lists. Each
+// option has name, short, long, aliases, nargs, n, type, required, enum,
+// help, metavar, requires, excludes, default, pattern, group and hidden. Empty
+// fields are left out except n, type, required and default, which is null
+// when the option has no default
+func (parser *Parser) Describe() ([]byte, error) {
+	return json.Marshal(parser.describe(parser.prog()))
+}
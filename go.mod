@@ -1,3 +1,10 @@
 module github.com/caligian/argparser
 
 go 1.22
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/term v0.20.0
+)
+
+require golang.org/x/sys v0.20.0 // indirect
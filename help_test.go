@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	text := "the quick brown fox jumps over the lazy dog and keeps running far away"
+	res := wrap(text, 4, 30)
+
+	lines := strings.Split(res, "\n")
+	if len(lines) < 2 {
+		t.Fatalf("text was not wrapped: %q", res)
+	}
+	for i, line := range lines {
+		if len(line) > 30 {
+			t.Errorf("line %d is longer than 30 columns: %q", i, line)
+		}
+		if i > 0 && (!strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "     ")) {
+			t.Errorf("line %d is not indented by 4: %q", i, line)
+		}
+	}
+	if got := strings.Join(strings.Fields(res), " "); got != text {
+		t.Errorf("words changed: %q", got)
+	}
+}
+
+func TestHelpWrapsAtWidth(t *testing.T) {
+	parser := New([]string{})
+	parser.Prog = "tool"
+	parser.SetWidth(60)
+	parser.Keyword("v", "verbose", &Option{
+		Help: "print every step of the work as it happens, including the ones that are usually skipped",
+	})
+
+	out := &bytes.Buffer{}
+	parser.WriteHelp(out)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if len(line) > 60 {
+			t.Errorf("line is longer than 60 columns: %q", line)
+		}
+	}
+	if !strings.Contains(out.String(), "skipped") {
+		t.Errorf("help text missing:\n%s", out.String())
+	}
+}
+
+func TestHiddenOption(t *testing.T) {
+	parser := New([]string{"--debug", "--fast"})
+	parser.Prog = "tool"
+	parser.Keyword("", "debug", &Option{Hidden: true, Help: "dump internals", Requires: []string{"trace"}})
+	parser.Keyword("", "trace", &Option{})
+	parser.Keyword("", "fast", &Option{})
+
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrMissingDeps) {
+		t.Errorf("got %v, want ErrMissingDeps", err)
+	}
+
+	parser.Reset()
+	parser.SetArgv([]string{"--debug", "--trace"})
+	mustParse(t, parser)
+	if !parser.IsSet("debug") {
+		t.Errorf("hidden switch was not parsed")
+	}
+
+	help := &bytes.Buffer{}
+	parser.WriteHelp(help)
+	usage := &bytes.Buffer{}
+	parser.WriteUsage(usage)
+	for _, out := range []string{help.String(), usage.String()} {
+		if strings.Contains(out, "debug") || strings.Contains(out, "dump internals") {
+			t.Errorf("hidden switch shown:\n%s", out)
+		}
+	}
+	if !strings.Contains(help.String(), "--trace") {
+		t.Errorf("visible switch missing:\n%s", help.String())
+	}
+}
+
+func TestExtremeWidths(t *testing.T) {
+	for cols, want := range map[int]int{0: minWidth, 10: minWidth, 60: 60, 300: maxWidth, 10000: maxWidth} {
+		if got := clampWidth(cols); got != want {
+			t.Errorf("clampWidth(%d) = %d, want %d", cols, got, want)
+		}
+	}
+
+	if got := New([]string{}).width(); got < minWidth || got > maxWidth {
+		t.Errorf("detected width %d is outside [%d, %d]", got, minWidth, maxWidth)
+	}
+
+	for _, cols := range []int{1, 10, 300} {
+		parser := New([]string{})
+		parser.Prog = "tool"
+		parser.SetWidth(cols)
+		parser.Keyword("v", "verbose", &Option{Help: "print every step of the work as it happens"})
+		parser.Argument("file", &Option{Help: "file to read"})
+
+		if got := parser.width(); got != cols {
+			t.Errorf("SetWidth(%d): got width %d", cols, got)
+		}
+
+		help := &bytes.Buffer{}
+		parser.WriteHelp(help)
+		words := strings.Join(strings.Fields(help.String()), " ")
+		if !strings.Contains(words, "as it happens") || !strings.Contains(words, "file to read") {
+			t.Errorf("width %d: help text missing:\n%s", cols, help.String())
+		}
+	}
+}
+
+func TestHelpRequested(t *testing.T) {
+	out := &bytes.Buffer{}
+	parser := New([]string{"--help"})
+	parser.Output = out
+	parser.Version("1.0")
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrHelpRequested) {
+		t.Errorf("got %v, want ErrHelpRequested", err)
+	}
+	if !strings.Contains(out.String(), "--help") {
+		t.Errorf("help not written: %q", out.String())
+	}
+
+	out.Reset()
+	parser = New([]string{"--version"})
+	parser.Output = out
+	parser.Version("1.0")
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrVersionRequested) || out.String() != "1.0\n" {
+		t.Errorf("got %v and %q, want ErrVersionRequested and the version", err, out.String())
+	}
+
+	// Parse without ExitOnHelp matches help like any other switch
+	out.Reset()
+	parser = New([]string{"-h"})
+	parser.Output = out
+	parser.Parse()
+	if !parser.IsSet("help") || out.Len() != 0 {
+		t.Errorf("got help set %v and output %q", parser.IsSet("help"), out.String())
+	}
+}
+
+func TestHelpWinsOverVersion(t *testing.T) {
+	for _, argv := range [][]string{{"--help", "--version"}, {"--version", "--help"}} {
+		for range 20 {
+			parser := New(slices.Clone(argv))
+			parser.Output = &bytes.Buffer{}
+			parser.Version("1.0")
+			if _, err := parser.ParseErr(); !errors.Is(err, ErrHelpRequested) {
+				t.Fatalf("%q: got %v, want ErrHelpRequested", argv, err)
+			}
+		}
+	}
+}
+
+func TestSubcommandHelpUsesRootOutput(t *testing.T) {
+	out := &bytes.Buffer{}
+	parser := New([]string{"sub", "--help"})
+	parser.Prog = "tool"
+	parser.Output = out
+	sub := parser.Subcommand("sub", &Option{Help: "a subcommand"})
+	sub.Keyword("", "only-in-sub", &Option{Help: "sub switch"})
+
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrHelpRequested) {
+		t.Fatalf("got %v, want ErrHelpRequested", err)
+	}
+	if !strings.Contains(out.String(), "tool sub") || !strings.Contains(out.String(), "--only-in-sub") {
+		t.Errorf("child help not written to root Output: %q", out.String())
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// manEscape escapes text for troff
+func manEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	s = strings.ReplaceAll(s, "-", `\-`)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+			lines[i] = `\&` + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WriteManPage writes a man(7) page for the command to w. NAME is made of
+// Prog and Summary and DESCRIPTION is Help
+func (parser *Parser) WriteManPage(w io.Writer, section int) {
+	prog := parser.prog()
+	fmt.Fprintf(w, ".TH %s %d\n", manEscape(strings.ToUpper(prog)), section)
+
+	fmt.Fprintln(w, ".SH NAME")
+	if parser.Summary != "" {
+		fmt.Fprintf(w, "%s \\- %s\n", manEscape(prog), manEscape(parser.Summary))
+	} else {
+		fmt.Fprintln(w, manEscape(prog))
+	}
+
+	synopsis := []string{}
+	for _, x := range parser.argumentsSlice {
+		if !x.opts.Hidden {
+			synopsis = append(synopsis, x.genHeader())
+		}
+	}
+	for _, x := range parser.keywordsOrder {
+		if !x.opts.Hidden {
+			synopsis = append(synopsis, x.genHeader(false, false))
+		}
+	}
+	if len(parser.visibleSubcommands()) > 0 {
+		synopsis = append(synopsis, "COMMAND ...")
+	}
+
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintf(w, ".B %s\n", manEscape(prog))
+	fmt.Fprintln(w, manEscape(strings.Join(synopsis, " ")))
+
+	if parser.Help != "" {
+		fmt.Fprintln(w, ".SH DESCRIPTION")
+		fmt.Fprintln(w, manEscape(parser.Help))
+	}
+
+	entry := func(header, help string) {
+		fmt.Fprintln(w, ".TP")
+		fmt.Fprintf(w, ".B %s\n", manEscape(header))
+		if help != "" {
+			fmt.Fprintln(w, manEscape(help))
+		}
+	}
+
+	arguments := []*argument{}
+	for _, x := range parser.argumentsSlice {
+		if !x.opts.Hidden {
+			arguments = append(arguments, x)
+		}
+	}
+
+	if len(arguments) > 0 {
+		fmt.Fprintln(w, ".SH ARGUMENTS")
+		for _, x := range arguments {
+			entry(x.genHeader(), x.opts.Help)
+		}
+	}
+
+	fmt.Fprintln(w, ".SH OPTIONS")
+	for _, x := range parser.keywordsOrder {
+		if !x.opts.Hidden {
+			entry(x.genHeader(true, false), x.opts.Help)
+		}
+	}
+
+	if subcommands := parser.visibleSubcommands(); len(subcommands) > 0 {
+		fmt.Fprintln(w, ".SH COMMANDS")
+		for _, x := range subcommands {
+			entry(x.name, x.opts.Help)
+		}
+	}
+}
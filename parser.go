@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"golang.org/x/term"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 type Option struct {
@@ -26,19 +33,169 @@ type Option struct {
 	Required        bool
 	Enum            []string
 	AllowDuplicates bool
+	Type            Type
+	// Default is used when the option is not passed. nil means the option
+	// is not present at all, while an empty slice is an explicitly empty
+	// value. Ignored for required options
+	Default []string
+	// Negatable switches also match --no-<LongName>. The value is
+	// recorded as "true" or "false" and the last occurrence wins
+	Negatable bool
+	// Count switches take no values and record the number of times they
+	// were passed, so -vvv is "3"
+	Count bool
+	// MapErr is like Map but can fail. Type, Enum and Assert are checked
+	// against the raw values first, then Map and MapErr are applied in
+	// that order
+	MapErr func(s string) (string, error)
+	// EnvVar is read when the keyword is not passed. Precedence is
+	// command line > EnvVar > sources added with AddSource > Default,
+	// unless EnvSource is added to place it elsewhere. An empty env var
+	// counts as unset unless AllowEmpty is set
+	EnvVar string
+	// EnumCaseInsensitive folds case when matching Enum and stores the
+	// canonical Enum entry as the value
+	EnumCaseInsensitive bool
+	// AllowFileRef replaces values like @path with the contents of path
+	// before any checks run. Use @@ for a literal leading @
+	AllowFileRef bool
+	// AllowStdin replaces a value of exactly - with the contents of stdin.
+	// Only one such value can be passed
+	AllowStdin bool
+	// Group is the heading the option is listed under in help. Ungrouped
+	// options are listed under Arguments and Keyword arguments
+	Group string
+	// Split splits each value on the delimiter before any checks run, so
+	// "a,b,,c" with Split "," is a, b and c. Empty fields are dropped. The
+	// number of values is checked against N/Nargs before splitting
+	Split string
+	// KeyValue requires every value to be a key=value pair. Use GetMap to
+	// read them. Combine with AllowDuplicates to let the switch repeat
+	KeyValue bool
+	// Aliases are extra long names matched like LongName. Values are
+	// recorded under the canonical Name
+	Aliases []string
+	// Example is shown on its own line under Help
+	Example string
+	// Flag marks a switch that never takes values, so in --verbose file,
+	// file is a positional arg. Like any switch it can be given a boolean,
+	// as in --verbose=false, which leaves it unset. N and Nargs must be
+	// unset
+	Flag bool
+	// Greedy options take every token after them up to `--` as values, even
+	// ones that look like switches, so --exec rm -rf /tmp passes rm, -rf and
+	// /tmp. Nargs defaults to *. The switch must be spelled out in full
+	Greedy bool
+	// AppendDefaults adds the values passed to the Default values instead of
+	// replacing them. With Default ["/usr/lib"], --path ~/lib gives
+	// ["/usr/lib", "~/lib"] and no --path gives ["/usr/lib"]. Values from
+	// EnvVar count as passed. Once combined, the defaults are checked and
+	// mapped like the passed values
+	AppendDefaults bool
+	// MapDefaults applies Map and MapErr to Default values too. Without it
+	// defaults are used as is. Type, Enum and Assert are checked either way
+	MapDefaults bool
+	// OnSet is called with the final values of the option once the whole
+	// parse has been validated, including for defaults. Callbacks run in
+	// registration order. An error aborts the parse with ErrOnSet
+	OnSet func(values []string) error
+	// Pattern is a regexp every value must match, e.g. "^[a-f0-9]{8}$". It
+	// is compiled on registration and shown in help
+	Pattern string
+	// AssertNamed is like Assert but also gets the option's name, so one
+	// validator can be shared by many options. It runs after Assert
+	AssertNamed func(name, value string) error
+	// AssertCtx and MapCtx are like Assert and MapErr but get the context
+	// passed to ParseContext, or context.Background. They run after Assert
+	// and MapErr
+	AssertCtx func(ctx context.Context, s string) error
+	MapCtx    func(ctx context.Context, s string) (string, error)
+	// Min and Max bound numeric values, inclusive. Values out of range fail
+	// with ErrAssertionFailure
+	Min *float64
+	Max *float64
+	// Hidden options are parsed and validated as usual but left out of help,
+	// usage and completions
+	Hidden bool
+	// Deprecated is written as a warning to ErrorWriter when the option is
+	// passed, e.g. "--old is deprecated, use --new". The parse goes on
+	Deprecated string
+	// AllowEmpty accepts "" as a value, e.g. --prefix "". Without it, empty
+	// values fail with ErrEmptyValue
+	AllowEmpty bool
+}
+
+// Type declares what values of an option should parse as. The zero value,
+// TypeString, accepts anything
+type Type int
+
+const (
+	TypeString Type = iota
+	TypeInt
+	TypeFloat
+	TypeBool
+	TypeDuration
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeBool:
+		return "bool"
+	case TypeDuration:
+		return "duration"
+	default:
+		return "string"
+	}
+}
+
+func (t Type) check(s string) error {
+	var err error
+	switch t {
+	case TypeInt:
+		_, err = strconv.Atoi(s)
+	case TypeFloat:
+		_, err = strconv.ParseFloat(s, 64)
+	case TypeBool:
+		_, err = strconv.ParseBool(s)
+	case TypeDuration:
+		_, err = time.ParseDuration(s)
+	}
+	return err
 }
 
 type argument struct {
-	name  string
-	value string
-	opts  *Option
+	name    string
+	value   string
+	opts    *Option
+	pattern *regexp.Regexp
 }
 
 type keyword struct {
-	name  string
-	pos   int
-	value string
-	opts  *Option
+	name     string
+	pos      int
+	value    string
+	hasValue bool
+	negated  bool
+	off      bool
+	opts     *Option
+	pattern  *regexp.Regexp
+}
+
+// oneOf is a group of options constrained together
+type oneOf struct {
+	names      []string
+	atLeastOne bool
+	atMostOne  bool
+}
+
+type subcommand struct {
+	name   string
+	opts   *Option
+	parser *Parser
 }
 
 type Parser struct {
@@ -47,6 +204,85 @@ type Parser struct {
 	ExitOnHelp bool
 	Parsed     map[string][]string
 	Summary    string
+	// Prog is the program name shown in the usage line. Defaults to the
+	// base name of os.Args[0]
+	Prog           string
+	version        string
+	helpName       string
+	versionName    string
+	completionName string
+	inputArgv      []string
+	inputTailArgv  []string
+	inputSaved     bool
+	helpErrors     bool
+	headArgv       []string
+	tailArgv       []string
+	restArgv       []string
+	allArgv        []string
+	argumentsMap   map[string]*argument
+	keywordsMap    map[string]*keyword
+	argumentsSlice []*argument
+	keywordsSlice  []*keyword
+	keywordsOrder  []*keyword
+	namesOrder     []string
+	parsedMap      map[string][]string
+	positionsMap   map[string][]int
+	checkDups      map[string]bool
+	defaultedMap   map[string]bool
+	passedMap      map[string]bool
+	offMap         map[string]bool
+	subcommands    []*subcommand
+	groups         []string
+	stdinRead      bool
+	unknownArgv    []string
+	greedyArgv     []string
+	origins        []int
+	argvOffset     int
+	bindings       []*binding
+	cols           int
+	ctx            context.Context
+	sources        []ValueSource
+	oneOf          []*oneOf
+	// AllowAbbrev lets long switches be passed as any unambiguous prefix,
+	// so --verb matches --verbose
+	AllowAbbrev bool
+	// ExitOnError makes parse errors print the usage and exit with
+	// ExitCode instead of panicking
+	ExitOnError bool
+	// ErrorWriter defaults to os.Stderr. Deprecation warnings are written
+	// here too
+	ErrorWriter io.Writer
+	// ErrorFormatter renders the error written by ExitOnError in place of
+	// the usage line followed by the error
+	ErrorFormatter func(err error) string
+	// ExitCode defaults to 2
+	ExitCode int
+	// AllowUnknown collects unrecognized switches for Unknown instead of
+	// failing with ErrUnknownFlag
+	AllowUnknown bool
+	// Color enables ANSI colors in help when Output is a terminal and
+	// NO_COLOR is not set
+	Color bool
+	// PostValidate runs after all options have been validated and can
+	// reject the whole parse, e.g. for invariants between options
+	PostValidate func(parsed map[string][]string) error
+	// Output is where help, usage and version are written. Defaults to
+	// os.Stdout
+	Output io.Writer
+	// SingleDashLong also matches long switches with a single dash, as in
+	// find -name. A token naming a long switch is never read as bundled
+	// short switches, so with --abc registered -abc is not -a -b -c
+	SingleDashLong bool
+	// PosixStrict stops matching switches at the first positional arg, as
+	// with POSIXLY_CORRECT, so in `-v file --force` --force is a positional
+	PosixStrict bool
+	// DisableSuggestions leaves out the "did you mean" hints for mistyped
+	// subcommands and long switches
+	DisableSuggestions bool
+	// EnvPrefix makes every keyword without an EnvVar read PREFIX_NAME,
+	// where NAME is its name uppercased with - replaced by _, so with
+	// "MYTOOL" --log-level reads MYTOOL_LOG_LEVEL
+	EnvPrefix string
 }
 
 //////////////////////////////////////////////////
@@ -62,6 +298,29 @@ var ErrInvalidChoice = errors.New("invalid choice")
 var ErrMissingDeps = errors.New("missing dependencies")
 var ErrUnallowedDeps = errors.New("unallowed dependencies passed")
 var ErrNameConflict = errors.New("cannot use the same name for positional args and switches")
+var ErrInvalidBundle = errors.New("unknown switch in bundled short switches")
+var ErrNotPassed = errors.New("option was not passed")
+var ErrInvalidValue = errors.New("invalid value")
+var ErrInvalidType = errors.New("value does not match the option type")
+var ErrMapFailure = errors.New("could not map value")
+var ErrFileRef = errors.New("could not read referenced file")
+var ErrStdin = errors.New("could not read stdin")
+var ErrAmbiguousAbbrev = errors.New("ambiguous abbreviation")
+var ErrUnknownFlag = errors.New("unknown switch")
+var ErrInvalidBind = errors.New("cannot bind value")
+var ErrValidation = errors.New("validation failed")
+var ErrInvalidPattern = errors.New("invalid pattern")
+var ErrUnknownCommand = errors.New("unknown command")
+var ErrGroupConstraint = errors.New("option group constraint failed")
+var ErrConfig = errors.New("invalid config")
+var ErrOnSet = errors.New("OnSet callback failed")
+var ErrEmptyValue = errors.New("empty value passed")
+var ErrHelpRequested = errors.New("help requested")
+var ErrVersionRequested = errors.New("version requested")
+
+// SubcommandKey is the reserved key in Parsed holding the name of the
+// selected subcommand
+const SubcommandKey = "@subcommand"
 
 //////////////////////////////////////////////////
 func getTermWidth() int {
@@ -78,43 +337,230 @@ func getTermWidth() int {
 }
 
 var numRe = regexp.MustCompile("^[0-9]+$")
-var nargsRe = regexp.MustCompile("^[+*?]+$")
-var headArgv = []string{}
-var tailArgv = []string{}
-var allArgv = []string{}
-var argumentsMap = map[string]*argument{}
-var keywordsMap = map[string]*keyword{}
-var argumentsSlice = []*argument{}
-var keywordsSlice = []*keyword{}
-var parsedMap = map[string][]string{}
-var checkDups = map[string]bool{}
-var termWidth = getTermWidth()
-var textWidth = termWidth / 2
+var nargsRe = regexp.MustCompile("^[+*?]$")
+var bundleRe = regexp.MustCompile("^-[^-].+$")
+var negNumRe = regexp.MustCompile(`^-\d*\.?\d+$`)
 
 //////////////////////////////////////////////////
+// New returns a parser for argv. argv holds only the args, so argv[0] is the
+// first arg and not the program name; use StripProg for slices that start
+// with it. os.Args is only read when argv is nil, in which case os.Args[1:]
+// is parsed and Prog is set from os.Args[0]
 func New(argv []string) *Parser {
-	if argv == nil {
-		argv = os.Args
-	}
-
-	eof := slices.Index(argv, "--")
-	if eof != -1 {
-		tailArgv = argv[eof+1:]
-		argv = argv[:eof]
+	prog := ""
+	if argv == nil && len(os.Args) > 0 {
+		prog = filepath.Base(os.Args[0])
+		argv = os.Args[1:]
 	}
 
 	parser := &Parser{
-		Argv: argv,
+		headArgv:       []string{},
+		tailArgv:       []string{},
+		restArgv:       []string{},
+		unknownArgv:    []string{},
+		greedyArgv:     []string{},
+		allArgv:        []string{},
+		argumentsMap:   map[string]*argument{},
+		keywordsMap:    map[string]*keyword{},
+		argumentsSlice: []*argument{},
+		keywordsSlice:  []*keyword{},
+		keywordsOrder:  []*keyword{},
+		namesOrder:     []string{},
+		parsedMap:      map[string][]string{},
+		positionsMap:   map[string][]int{},
+		checkDups:      map[string]bool{},
+		defaultedMap:   map[string]bool{},
+		passedMap:      map[string]bool{},
+		offMap:         map[string]bool{},
 	}
 
+	parser.Prog = prog
+	parser.sources = []ValueSource{envSource{parser}}
+	parser.SetArgv(argv)
 	parser.Keyword(
 		"h", "help",
 		&Option{Help: "show this help"},
 	)
+	parser.helpName = "help"
+
+	return parser
+}
+
+// SetArgv sets the args to parse. Everything after the first `--` is split
+// off as it is in New. Those args are literal, further `--` included, and
+// are never matched as switches or subcommands
+func (parser *Parser) SetArgv(argv []string) *Parser {
+	parser.inputSaved = false
+	parser.tailArgv = []string{}
+	parser.restArgv = []string{}
+
+	eof := slices.Index(argv, "--")
+	if eof != -1 {
+		parser.tailArgv = argv[eof+1:]
+		parser.restArgv = slices.Clone(parser.tailArgv)
+		argv = argv[:eof]
+	}
+
+	parser.Argv = argv
+	return parser
+}
+
+// StripProg removes the program name from the front of Argv, for argv
+// slices laid out like os.Args, and uses it as Prog unless Prog is set
+func (parser *Parser) StripProg() *Parser {
+	if len(parser.Argv) == 0 {
+		return parser
+	}
 
+	if parser.Prog == "" {
+		parser.Prog = filepath.Base(parser.Argv[0])
+	}
+	parser.Argv = parser.Argv[1:]
+	return parser
+}
+
+// Reset clears the results of the last parse so the parser can be run
+// again. Registered options and subcommands survive Reset. Parsing rewrites
+// Argv, so Reset puts back Argv and the args after `--` as they were before
+// the parse. Use SetArgv to parse new input
+func (parser *Parser) Reset() *Parser {
+	if parser.inputSaved {
+		parser.Argv = slices.Clone(parser.inputArgv)
+		parser.tailArgv = slices.Clone(parser.inputTailArgv)
+		parser.restArgv = slices.Clone(parser.inputTailArgv)
+		parser.inputSaved = false
+	}
+
+	parser.Parsed = nil
+	parser.headArgv = []string{}
+	parser.allArgv = []string{}
+	parser.unknownArgv = []string{}
+	parser.greedyArgv = []string{}
+	parser.origins = []int{}
+	parser.argvOffset = 0
+	parser.keywordsSlice = []*keyword{}
+	parser.parsedMap = map[string][]string{}
+	parser.positionsMap = map[string][]int{}
+	parser.checkDups = map[string]bool{}
+	parser.defaultedMap = map[string]bool{}
+	parser.passedMap = map[string]bool{}
+	parser.offMap = map[string]bool{}
+	parser.stdinRead = false
+
+	for _, x := range parser.subcommands {
+		x.parser.Reset()
+	}
+
+	return parser
+}
+
+// Clone returns a copy of the parser with its own copies of the registered
+// options and subcommands. Like Reset, the results of the last parse are not
+// copied. Fields filled by Bind are not carried over
+func (parser *Parser) Clone() *Parser {
+	res := *parser
+	res.argumentsMap = map[string]*argument{}
+	res.argumentsSlice = []*argument{}
+	res.keywordsMap = map[string]*keyword{}
+	res.keywordsOrder = []*keyword{}
+	res.subcommands = []*subcommand{}
+	res.bindings = []*binding{}
+	res.Argv = slices.Clone(parser.Argv)
+	res.tailArgv = slices.Clone(parser.tailArgv)
+	res.restArgv = slices.Clone(parser.restArgv)
+	res.namesOrder = slices.Clone(parser.namesOrder)
+	res.groups = slices.Clone(parser.groups)
+	res.sources = []ValueSource{}
+	for _, source := range parser.sources {
+		if _, ok := source.(envSource); ok {
+			source = envSource{&res}
+		}
+		res.sources = append(res.sources, source)
+	}
+	res.oneOf = slices.Clone(parser.oneOf)
+
+	for _, x := range parser.argumentsSlice {
+		y := *x
+		y.opts = x.opts.clone()
+		res.argumentsMap[y.name] = &y
+		res.argumentsSlice = append(res.argumentsSlice, &y)
+	}
+
+	for _, x := range parser.keywordsOrder {
+		y := *x
+		y.opts = x.opts.clone()
+		res.keywordsMap[y.name] = &y
+		res.keywordsOrder = append(res.keywordsOrder, &y)
+	}
+
+	for _, x := range parser.subcommands {
+		res.subcommands = append(res.subcommands, &subcommand{
+			name:   x.name,
+			opts:   x.opts.clone(),
+			parser: x.parser.Clone(),
+		})
+	}
+
+	return res.Reset()
+}
+
+func (opts *Option) clone() *Option {
+	res := *opts
+	res.Requires = slices.Clone(opts.Requires)
+	res.Excludes = slices.Clone(opts.Excludes)
+	res.Enum = slices.Clone(opts.Enum)
+	res.Default = slices.Clone(opts.Default)
+	res.Aliases = slices.Clone(opts.Aliases)
+	if opts.Min != nil {
+		x := *opts.Min
+		res.Min = &x
+	}
+	if opts.Max != nil {
+		x := *opts.Max
+		res.Max = &x
+	}
+	return &res
+}
+
+// RequireOneOf fails the parse with ErrGroupConstraint unless at least one
+// of the named options is passed or set from the environment or a source.
+// Defaults do not count. The options must already be registered
+func (parser *Parser) RequireOneOf(names ...string) *Parser {
+	parser.addOneOf(&oneOf{names: names, atLeastOne: true})
+	return parser
+}
+
+// AtMostOneOf fails the parse with ErrGroupConstraint when more than one of
+// the named options is passed or set from the environment or a source.
+// Defaults do not count. Use it with RequireOneOf for exactly one
+func (parser *Parser) AtMostOneOf(names ...string) *Parser {
+	parser.addOneOf(&oneOf{names: names, atMostOne: true})
 	return parser
 }
 
+func (parser *Parser) addOneOf(group *oneOf) {
+	for _, name := range group.names {
+		if parser.options(name) == nil {
+			panic(fmt.Errorf("%w\nreason: unknown option %s\n", ErrGroupConstraint, name))
+		}
+	}
+	parser.oneOf = append(parser.oneOf, group)
+}
+
+// compilePattern compiles Pattern, panicking with ErrInvalidPattern when it
+// is not a valid regexp
+func compilePattern(opts *Option) *regexp.Regexp {
+	if opts.Pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(opts.Pattern)
+	if err != nil {
+		panic(fmt.Errorf("%w\nOption: %#v\nreason: %v\n", ErrInvalidPattern, opts, err))
+	}
+	return re
+}
+
 func (parser *Parser) Argument(name string, opts *Option) *Parser {
 	opts.Name = name
 
@@ -122,25 +568,98 @@ func (parser *Parser) Argument(name string, opts *Option) *Parser {
 		panic(fmt.Errorf("%w\nParser: %#v\n", ErrMissingName, parser))
 	}
 
-	if _, ok := argumentsMap[name]; ok {
+	if _, ok := parser.argumentsMap[name]; ok {
 		panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
 	}
 
-	if _, ok := keywordsMap[name]; ok {
+	if _, ok := parser.keywordsMap[name]; ok {
 		panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
 	}
 
-	argumentsMap[opts.Name] = &argument{
-		name:  opts.Name,
-		value: "",
-		opts:  opts,
+	if opts.Nargs != "" && !nargsRe.MatchString(opts.Nargs) {
+		panic(fmt.Errorf("%w\nOption: %#v\n", ErrInvalidNargs, opts))
+	}
+
+	parser.argumentsMap[opts.Name] = &argument{
+		name:    opts.Name,
+		value:   "",
+		opts:    opts,
+		pattern: compilePattern(opts),
+	}
+
+	parser.argumentsSlice = append(parser.argumentsSlice, parser.argumentsMap[opts.Name])
+	parser.namesOrder = append(parser.namesOrder, opts.Name)
+	parser.addGroup(opts.Group)
+
+	return parser
+}
+
+// longNames returns the names and aliases of all keywords
+func (parser *Parser) longNames() map[string]bool {
+	res := map[string]bool{}
+	for name, x := range parser.keywordsMap {
+		res[name] = true
+		if x.opts.LongName != "" {
+			res[x.opts.LongName] = true
+		}
+		for _, alias := range x.opts.Aliases {
+			res[alias] = true
+		}
+	}
+	return res
+}
+
+// shortNames returns the short names of all keywords
+func (parser *Parser) shortNames() map[string]bool {
+	res := map[string]bool{}
+	for _, x := range parser.keywordsMap {
+		if x.opts.ShortName != "" {
+			res[x.opts.ShortName] = true
+		}
+	}
+	return res
+}
+
+func (parser *Parser) removeKeyword(name string) {
+	delete(parser.keywordsMap, name)
+	parser.namesOrder = slices.DeleteFunc(parser.namesOrder, func(x string) bool {
+		return x == name
+	})
+	parser.keywordsOrder = slices.DeleteFunc(parser.keywordsOrder, func(x *keyword) bool {
+		return x.name == name
+	})
+}
+
+// DisableHelp unregisters the automatic -h/--help switch so that those
+// names can be used for other keywords
+func (parser *Parser) DisableHelp() *Parser {
+	if parser.helpName != "" {
+		parser.removeKeyword(parser.helpName)
+		parser.helpName = ""
 	}
+	return parser
+}
+
+// HelpFlag replaces the automatic -h/--help switch with one using the given
+// names
+func (parser *Parser) HelpFlag(short, long string) *Parser {
+	parser.DisableHelp()
+	parser.Keyword(short, long, &Option{Help: "show this help"})
 
-	argumentsSlice = append(argumentsSlice, argumentsMap[opts.Name])
+	// keep help listed first, as it is by default
+	x := parser.keywordsOrder[len(parser.keywordsOrder)-1]
+	parser.keywordsOrder = slices.Insert(parser.keywordsOrder[:len(parser.keywordsOrder)-1], 0, x)
+	parser.helpName = x.name
 
 	return parser
 }
 
+func (parser *Parser) addGroup(group string) {
+	if group != "" && !slices.Contains(parser.groups, group) {
+		parser.groups = append(parser.groups, group)
+	}
+}
+
 func (parser *Parser) Keyword(short, long string, opts *Option) *Parser {
 	if (short == "") && (long == "") {
 		panic(fmt.Errorf("%w\nParser: %#v\n", ErrMissingName, parser))
@@ -158,288 +677,1993 @@ func (parser *Parser) Keyword(short, long string, opts *Option) *Parser {
 		}
 	}
 
-	if _, ok := argumentsMap[opts.Name]; ok {
+	if _, ok := parser.argumentsMap[opts.Name]; ok {
+		panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
+	}
+
+	if _, ok := parser.keywordsMap[opts.Name]; ok {
 		panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
 	}
 
-	if _, ok := keywordsMap[opts.Name]; ok {
-		panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
+	if opts.ShortName != "" && parser.shortNames()[opts.ShortName] {
+		panic(fmt.Errorf("%w\nOption: %#v\nreason: -%s is already taken\n", ErrNameConflict, opts, opts.ShortName))
+	}
+
+	// --no-<name> of negatable switches is taken too, and with
+	// SingleDashLong -a could be either a short or a long name
+	taken := parser.longNames()
+	for _, x := range parser.keywordsMap {
+		if x.opts.Negatable && x.opts.LongName != "" {
+			taken["no-"+x.opts.LongName] = true
+		}
+	}
+	if parser.SingleDashLong {
+		if opts.ShortName != "" && taken[opts.ShortName] {
+			panic(fmt.Errorf("%w\nOption: %#v\nreason: -%s is already a long name\n", ErrNameConflict, opts, opts.ShortName))
+		}
+		for name := range parser.shortNames() {
+			taken[name] = true
+		}
+	}
+
+	names := append([]string{opts.LongName}, opts.Aliases...)
+	if opts.Negatable && opts.LongName != "" {
+		names = append(names, "no-"+opts.LongName)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		} else if _, ok := parser.argumentsMap[name]; ok || taken[name] {
+			panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
+		}
+		taken[name] = true
+	}
+
+	if opts.Flag && (opts.N != 0 || opts.Nargs != "") {
+		panic(fmt.Errorf("%w\nOption: %#v\nreason: flags take no values\n", ErrInvalidNargs, opts))
+	}
+
+	if opts.Greedy && opts.Nargs != "+" {
+		opts.Nargs = "*"
+	}
+
+	nargs := &opts.Nargs
+	if *nargs != "" {
+		if nargsRe.FindStringIndex(*nargs) == nil {
+			panic(fmt.Errorf("%w\nOption: %#v\n", ErrInvalidNargs, opts))
+		}
+		opts.N = -1
+	}
+
+	parser.keywordsMap[opts.Name] = &keyword{
+		name:    opts.Name,
+		pos:     -1,
+		value:   "",
+		opts:    opts,
+		pattern: compilePattern(opts),
+	}
+	parser.keywordsOrder = append(parser.keywordsOrder, parser.keywordsMap[opts.Name])
+	parser.namesOrder = append(parser.namesOrder, opts.Name)
+	parser.addGroup(opts.Group)
+
+	return parser
+}
+
+// expandBundles rewrites bundled short switches such as `-abc` into `-a -b
+// -c`. If a bundled switch takes arguments, the rest of the token becomes its
+// value, so `-an5` is `-a -n=5`. When it is the last in the bundle it takes
+// the following args as usual, so tar style `-czf out.tar` is `-c -z -f
+// out.tar`
+func (parser *Parser) expandBundles(argv []string) []string {
+	shortNames := map[string]*keyword{}
+	for _, x := range parser.keywordsMap {
+		if x.opts.ShortName != "" {
+			shortNames[x.opts.ShortName] = x
+		}
+	}
+	digitNames := parser.hasDigitNames()
+
+	singleDashNames := parser.longNames()
+	for _, x := range parser.keywordsMap {
+		if x.opts.Negatable && x.opts.LongName != "" {
+			singleDashNames["no-"+x.opts.LongName] = true
+		}
+	}
+
+	res := []string{}
+	for _, token := range argv {
+		if !bundleRe.MatchString(token) {
+			res = append(res, token)
+			continue
+		} else if _, ok := shortNames[token[1:]]; ok {
+			res = append(res, token)
+			continue
+		} else if name, _, _ := strings.Cut(token[1:], "="); parser.SingleDashLong && singleDashNames[name] {
+			res = append(res, token)
+			continue
+		} else if !digitNames && negNumRe.MatchString(token) {
+			// negative numbers are values unless digits are used as
+			// short names
+			res = append(res, token)
+			continue
+		}
+
+		bundle := []rune(token[1:])
+		for i, c := range bundle {
+			x, ok := shortNames[string(c)]
+			if !ok && parser.AllowUnknown {
+				res = append(res, token)
+				break
+			} else if !ok {
+				panic(fmt.Errorf("%w\nswitch: -%c\ntoken: %s\n", ErrInvalidBundle, c, token))
+			}
+
+			if x.opts.N == 0 && x.opts.Nargs == "" {
+				// a switch can only take a boolean, as in -v=false
+				rest := string(bundle[i+1:])
+				if !x.opts.Count && strings.HasPrefix(rest, "=") {
+					res = append(res, "-"+string(c)+rest)
+					break
+				}
+				res = append(res, "-"+string(c))
+				continue
+			}
+
+			rest := string(bundle[i+1:])
+			if rest == "" {
+				res = append(res, "-"+string(c))
+			} else if strings.HasPrefix(rest, "=") {
+				res = append(res, "-"+string(c)+rest)
+			} else {
+				res = append(res, "-"+string(c)+"="+rest)
+			}
+			break
+		}
+	}
+
+	return res
+}
+
+// expandAbbrevs rewrites unambiguous prefixes of long switches to the full
+// switch. Exact matches are always kept as is
+func (parser *Parser) expandAbbrevs(argv []string) []string {
+	longNames := []string{}
+	for _, x := range parser.keywordsMap {
+		if x.opts.LongName == "" {
+			continue
+		}
+
+		longNames = append(longNames, x.opts.LongName)
+		longNames = append(longNames, x.opts.Aliases...)
+		if x.opts.Negatable {
+			longNames = append(longNames, "no-"+x.opts.LongName)
+		}
+	}
+	slices.Sort(longNames)
+
+	res := []string{}
+	for _, token := range argv {
+		if token == "--" || !strings.HasPrefix(token, "--") {
+			res = append(res, token)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(token[2:], "=")
+		if slices.Contains(longNames, name) {
+			res = append(res, token)
+			continue
+		}
+
+		candidates := []string{}
+		for _, long := range longNames {
+			if strings.HasPrefix(long, name) {
+				candidates = append(candidates, long)
+			}
+		}
+
+		switch len(candidates) {
+		case 0:
+			res = append(res, token)
+		case 1:
+			if hasValue {
+				res = append(res, "--"+candidates[0]+"="+value)
+			} else {
+				res = append(res, "--"+candidates[0])
+			}
+		default:
+			panic(fmt.Errorf(
+				"%w\ntoken: %s\ncandidates: --%s\n",
+				ErrAmbiguousAbbrev,
+				token,
+				strings.Join(candidates, ", --"),
+			))
+		}
+	}
+
+	return res
+}
+
+// Version registers -v/--version to print version. Like help, it exits when
+// ExitOnHelp is set and otherwise makes ParseErr fail with
+// ErrVersionRequested
+func (parser *Parser) Version(version string) *Parser {
+	return parser.VersionFlag("v", "version", version)
+}
+
+// VersionFlag is like Version but with custom switch names, for when -v is
+// already taken
+func (parser *Parser) VersionFlag(short, long, version string) *Parser {
+	parser.Keyword(short, long, &Option{Help: "show version"})
+	parser.version = version
+	if long != "" {
+		parser.versionName = long
+	} else {
+		parser.versionName = short
+	}
+
+	return parser
+}
+
+// Subcommand registers a subcommand and returns its parser. When name is
+// found among the positional args, everything after it is parsed by the
+// returned parser
+func (parser *Parser) Subcommand(name string, opts *Option) *Parser {
+	if name == "" {
+		panic(fmt.Errorf("%w\nParser: %#v\n", ErrMissingName, parser))
+	}
+
+	for _, x := range parser.subcommands {
+		if x.name == name {
+			panic(fmt.Errorf("%w\nOption: %#v\n", ErrNameConflict, opts))
+		}
+	}
+
+	opts.Name = name
+	child := New([]string{})
+	parser.subcommands = append(parser.subcommands, &subcommand{
+		name:   name,
+		opts:   opts,
+		parser: child,
+	})
+
+	return child
+}
+
+// suggestFlag returns a hint naming the long switch closest to token
+func (parser *Parser) suggestFlag(token string) string {
+	name, _, _ := strings.Cut(strings.TrimLeft(token, "-"), "=")
+	if parser.DisableSuggestions || !strings.HasPrefix(token, "--") {
+		return ""
+	}
+
+	names := []string{}
+	for _, x := range parser.keywordsOrder {
+		if x.opts.Hidden || x.opts.LongName == "" {
+			continue
+		}
+		names = append(names, x.opts.LongName)
+		names = append(names, x.opts.Aliases...)
+	}
+
+	if match := suggest(name, names); match != "" {
+		return fmt.Sprintf("did you mean --%s?\n", match)
+	}
+	return ""
+}
+
+// visibleSubcommands returns the subcommands not marked Hidden
+func (parser *Parser) visibleSubcommands() []*subcommand {
+	res := []*subcommand{}
+	for _, x := range parser.subcommands {
+		if !x.opts.Hidden {
+			res = append(res, x)
+		}
+	}
+	return res
+}
+
+func (parser *Parser) unknownCommand(name string) {
+	names := []string{}
+	for _, x := range parser.visibleSubcommands() {
+		names = append(names, x.name)
+	}
+
+	hint := ""
+	if match := suggest(name, names); match != "" && !parser.DisableSuggestions {
+		hint = fmt.Sprintf("did you mean '%s'?\n", match)
+	}
+
+	panic(fmt.Errorf("%w\ncommand: %s\n%s", ErrUnknownCommand, name, hint))
+}
+
+// dispatch hands everything after the first positional arg naming a
+// subcommand to that subcommand's parser. Switch values are never read as
+// subcommand names, so `--name commit commit` runs commit
+func (parser *Parser) dispatch() *subcommand {
+	if len(parser.subcommands) == 0 {
+		return nil
+	}
+
+	for _, i := range parser.positionals(parser.Argv) {
+		v := parser.Argv[i]
+		for _, x := range parser.subcommands {
+			if x.name != v {
+				continue
+			}
+
+			// the child prints help and errors the way the root does,
+			// unless it was set up otherwise
+			child := x.parser
+			child.helpErrors = parser.helpErrors
+			child.ExitOnHelp = child.ExitOnHelp || parser.ExitOnHelp
+			child.Color = child.Color || parser.Color
+			if child.Output == nil {
+				child.Output = parser.Output
+			}
+			if child.ErrorWriter == nil {
+				child.ErrorWriter = parser.ErrorWriter
+			}
+			if child.cols == 0 {
+				child.cols = parser.cols
+			}
+			child.Argv = parser.Argv[i+1:]
+			child.argvOffset = parser.argvOffset + i + 1
+			child.tailArgv = parser.tailArgv
+			child.restArgv = parser.restArgv
+			if child.Prog == "" {
+				child.Prog = parser.prog() + " " + x.name
+			}
+
+			parser.Argv = parser.Argv[:i]
+			parser.tailArgv = []string{}
+			parser.restArgv = []string{}
+
+			return x
+		}
+	}
+
+	return nil
+}
+
+func (parser *Parser) hasDigitNames() bool {
+	for _, x := range parser.keywordsMap {
+		if x.opts.ShortName != "" && unicode.IsDigit(rune(x.opts.ShortName[0])) {
+			return true
+		}
+	}
+	return false
+}
+
+// findUnknown returns the positions of tokens that look like switches but
+// were not matched. A lone - and negative numbers are not switches
+func (parser *Parser) findUnknown(argv []string) []int {
+	matched := map[int]bool{}
+	for _, x := range parser.keywordsSlice {
+		matched[x.pos] = true
+	}
+
+	digitNames := parser.hasDigitNames()
+	res := []int{}
+	for i, v := range argv {
+		if matched[i] || v == "-" || !strings.HasPrefix(v, "-") {
+			continue
+		} else if !digitNames && negNumRe.MatchString(v) {
+			continue
+		}
+		res = append(res, i)
+	}
+
+	return res
+}
+
+// Names returns the names of all keywords and positional args in
+// registration order
+func (parser *Parser) Names() []string {
+	return slices.Clone(parser.namesOrder)
+}
+
+// Keywords returns deep copies of the options of all keywords in
+// registration order
+func (parser *Parser) Keywords() []Option {
+	res := []Option{}
+	for _, x := range parser.keywordsOrder {
+		res = append(res, *x.opts.clone())
+	}
+	return res
+}
+
+// Arguments returns deep copies of the options of all positional args in
+// registration order
+func (parser *Parser) Arguments() []Option {
+	res := []Option{}
+	for _, x := range parser.argumentsSlice {
+		res = append(res, *x.opts.clone())
+	}
+	return res
+}
+
+// Unknown returns the unrecognized switches collected when AllowUnknown is
+// set
+func (parser *Parser) Unknown() []string {
+	return parser.unknownArgv
+}
+
+// stopOnHelp reports whether help and version end the parse. Otherwise, as
+// with Parse without ExitOnHelp, they are matched like any other switch
+func (parser *Parser) stopOnHelp() bool {
+	return parser.ExitOnHelp || parser.helpErrors
+}
+
+// helpDone ends the parse once help or version has been written. It exits
+// when ExitOnHelp is set and otherwise panics with err for ParseErr to return
+func (parser *Parser) helpDone(err error) {
+	if parser.ExitOnHelp {
+		os.Exit(0)
+	}
+	panic(err)
+}
+
+// cutGreedy splits argv after the first greedy switch so that its values
+// are never read as switches
+func (parser *Parser) cutGreedy(argv []string) []string {
+	for i, token := range argv {
+		name, _, _ := strings.Cut(token, "=")
+		for _, x := range parser.keywordsOrder {
+			opts := x.opts
+			if !opts.Greedy {
+				continue
+			}
+
+			names := []string{}
+			if opts.ShortName != "" {
+				names = append(names, "-"+opts.ShortName)
+			}
+			if opts.LongName != "" {
+				names = append(names, "--"+opts.LongName)
+				if parser.SingleDashLong {
+					names = append(names, "-"+opts.LongName)
+				}
+			}
+			for _, alias := range opts.Aliases {
+				names = append(names, "--"+alias)
+				if parser.SingleDashLong {
+					names = append(names, "-"+alias)
+				}
+			}
+
+			if slices.Contains(names, name) {
+				parser.greedyArgv = slices.Clone(argv[i+1:])
+				return argv[:i+1]
+			}
+		}
+	}
+
+	return argv
+}
+
+// positionals returns the indices of the positional args in argv, that is
+// of the tokens that are neither switches nor the values they take. It
+// reads argv the way Find and Extract do without changing it, so bundles,
+// abbreviations and greedy switches are resolved here too
+func (parser *Parser) positionals(argv []string) []int {
+	names := map[string]*Option{}
+	add := func(prefix, name string, opts *Option) {
+		names[prefix+name] = opts
+		if parser.SingleDashLong && prefix != "-" {
+			names["-"+strings.TrimPrefix(prefix, "--")+name] = opts
+		}
+	}
+	for _, x := range parser.keywordsOrder {
+		opts := x.opts
+		if opts.ShortName != "" {
+			add("-", opts.ShortName, opts)
+		}
+		if opts.LongName != "" {
+			add("--", opts.LongName, opts)
+			if opts.Negatable {
+				add("--no-", opts.LongName, opts)
+			}
+		}
+		for _, alias := range opts.Aliases {
+			add("--", alias, opts)
+		}
+	}
+	digitNames := parser.hasDigitNames()
+
+	// lookup returns the option the switch token ends with and whether
+	// a value is attached to it. Unknown switches give nil
+	lookup := func(token string) (*Option, bool) {
+		name, _, hasValue := strings.Cut(token, "=")
+		if opts, ok := names[name]; ok {
+			return opts, hasValue
+		} else if parser.AllowAbbrev && strings.HasPrefix(name, "--") {
+			var match *Option
+			for long, opts := range names {
+				if !strings.HasPrefix(long, name) {
+					continue
+				} else if match != nil && match != opts {
+					return nil, false
+				}
+				match = opts
+			}
+			return match, hasValue
+		} else if !bundleRe.MatchString(token) {
+			return nil, false
+		}
+
+		var last *Option
+		for i, c := range token[1:] {
+			opts, ok := names["-"+string(c)]
+			if !ok {
+				return nil, false
+			} else if opts.N != 0 || opts.Nargs != "" {
+				return opts, i+2 < len(token)
+			}
+			last = opts
+		}
+		return last, false
+	}
+
+	isSwitch := func(token string) bool {
+		if token == "-" || !strings.HasPrefix(token, "-") {
+			return false
+		}
+		return digitNames || !negNumRe.MatchString(token)
+	}
+
+	res := []int{}
+	for i := 0; i < len(argv); i++ {
+		token := argv[i]
+		if !isSwitch(token) {
+			res = append(res, i)
+			continue
+		}
+
+		opts, hasValue := lookup(token)
+		if opts == nil {
+			// unknown switches are left to findUnknown
+			continue
+		} else if opts.Greedy {
+			// everything after a greedy switch is its value
+			break
+		}
+
+		take := opts.N
+		if opts.Nargs == "?" {
+			take = 1
+		}
+		if hasValue && take > 0 {
+			take--
+		}
+
+		for ; take != 0 && i+1 < len(argv); take-- {
+			if next, _ := lookup(argv[i+1]); next != nil && isSwitch(argv[i+1]) {
+				break
+			}
+			i++
+		}
+	}
+
+	return res
+}
+
+// posixEnd returns the index of the first positional arg in argv when
+// PosixStrict is set, and len(argv) otherwise. Values of switches are
+// skipped, so in `-n 1 file` the first positional is file
+func (parser *Parser) posixEnd(argv []string) int {
+	if xs := parser.positionals(argv); parser.PosixStrict && len(xs) > 0 {
+		return xs[0]
+	}
+	return len(argv)
+}
+
+func (parser *Parser) Find() {
+	// with PosixStrict the args from the first positional on are left as
+	// they are
+	input := parser.Argv
+	end := parser.posixEnd(input)
+	posix := slices.Clone(input[end:])
+	head := parser.cutGreedy(input[:end])
+
+	// bundles and abbreviations are expanded token by token so that errors
+	// can name the position of the token as it was passed. origins holds
+	// that position for each token of argv, then for the greedy args, the
+	// `--` and the args after it, in the order Extract reads them
+	argv := []string{}
+	parser.origins = []int{}
+	for i, token := range head {
+		expanded := parser.expandBundles([]string{token})
+		if parser.AllowAbbrev {
+			expanded = parser.expandAbbrevs(expanded)
+		}
+		argv = append(argv, expanded...)
+		for range expanded {
+			parser.origins = append(parser.origins, i)
+		}
+	}
+	for i := range posix {
+		parser.origins = append(parser.origins, end+i)
+	}
+	for i := range parser.greedyArgv {
+		parser.origins = append(parser.origins, len(head)+i)
+	}
+	for i := 0; i <= len(parser.tailArgv); i++ {
+		parser.origins = append(parser.origins, len(input)+i)
+	}
+
+	end = len(argv)
+	argv = append(argv, posix...)
+	parser.Argv = argv
+
+	matches := func(prefix string, a string, b string) bool {
+		return (prefix + a) == b
+	}
+
+	matchesLong := func(prefix string, a string, b string) bool {
+		if parser.SingleDashLong && matches("-"+prefix, a, b) {
+			return true
+		}
+		return matches("--"+prefix, a, b)
+	}
+
+	// split `--name=value` and `-n=value` into the switch and its value.
+	// `--` alone is the end-of-options marker and is never split
+	split := func(v string) (string, string, bool) {
+		if v == "--" || !strings.HasPrefix(v, "-") {
+			return v, "", false
+		}
+
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return v, "", false
+		}
+
+		return name, value, true
+	}
+
+	find := func(x *keyword) {
+		opts := x.opts
+		dup := opts.AllowDuplicates
+
+		for i, token := range argv[:end] {
+			v, value, hasValue := split(token)
+			matched := -1
+			if opts.ShortName != "" && matches("-", opts.ShortName, v) {
+				if opts.Name == parser.helpName && parser.stopOnHelp() {
+					parser.PrintUsage()
+					parser.helpDone(ErrHelpRequested)
+				}
+				matched = i
+			}
+
+			if matched == -1 && opts.LongName != "" && matchesLong("", opts.LongName, v) {
+				if opts.Name == parser.helpName && parser.stopOnHelp() {
+					parser.PrintHelp()
+					parser.helpDone(ErrHelpRequested)
+				}
+				matched = i
+			}
+
+			for _, alias := range opts.Aliases {
+				if matched == -1 && matchesLong("", alias, v) {
+					matched = i
+				}
+			}
+
+			negated := false
+			if matched == -1 && opts.Negatable && opts.LongName != "" && matchesLong("no-", opts.LongName, v) {
+				matched = i
+				negated = true
+			}
+
+			if matched != -1 && opts.Name == parser.versionName && parser.stopOnHelp() {
+				fmt.Fprintln(parser.output(), parser.version)
+				parser.helpDone(ErrVersionRequested)
+			}
+
+			// switches take an attached boolean, as in --verbose=false.
+			// False leaves a plain switch unset, as it does in env vars
+			off := false
+			if matched != -1 && hasValue && opts.N == 0 && opts.Nargs == "" && !opts.Count {
+				b, err := strconv.ParseBool(value)
+				if err != nil {
+					panic(fmt.Errorf(
+						"%w\nswitch: %s\nreason: expected a boolean\ntoken: %q\nposition: %d\n",
+						ErrInvalidValue,
+						opts.Name,
+						token,
+						parser.origin(i),
+					))
+				} else if opts.Negatable {
+					negated = b == negated
+				} else {
+					off = !b
+				}
+				value, hasValue = "", false
+			}
+
+			if matched != -1 {
+				y := *x
+				y.pos = i
+				y.value = value
+				y.hasValue = hasValue
+				y.negated = negated
+				y.off = off
+				parser.keywordsSlice = append(parser.keywordsSlice, &y)
+				if !off {
+					parser.passedMap[opts.Name] = true
+				}
+				if parser.checkDups[opts.Name] && !dup && !opts.Negatable && !opts.Count {
+					panic(fmt.Errorf("%w\nkeyword arg: %#v\n", ErrDuplicate, x))
+				} else {
+					parser.checkDups[opts.Name] = true
+				}
+			}
+		}
+	}
+
+	// in declaration order so that help wins over version when both are
+	// given
+	for _, v := range parser.keywordsOrder {
+		find(v)
+	}
+
+	if unknown := parser.findUnknown(argv[:end]); len(unknown) > 0 {
+		if !parser.AllowUnknown {
+			token := argv[unknown[0]]
+			panic(fmt.Errorf(
+				"%w\ntoken: %s\nposition: %d\n%s",
+				ErrUnknownFlag,
+				token,
+				parser.origin(unknown[0]),
+				parser.suggestFlag(token),
+			))
+		}
+
+		// drop the unknown switches and match again so that they are not
+		// taken as values or positional args
+		known := []string{}
+		origins := []int{}
+		for i, v := range argv {
+			if slices.Contains(unknown, i) {
+				parser.unknownArgv = append(parser.unknownArgv, v)
+			} else {
+				known = append(known, v)
+				origins = append(origins, parser.origins[i])
+			}
+		}
+		parser.origins = append(origins, parser.origins[len(argv):]...)
+
+		parser.Argv = known
+		argv = known
+		end -= len(unknown)
+		parser.keywordsSlice = []*keyword{}
+		parser.passedMap = map[string]bool{}
+		parser.checkDups = map[string]bool{}
+
+		for _, v := range parser.keywordsOrder {
+			find(v)
+		}
+	}
+
+	for _, x := range parser.keywordsOrder {
+		name := x.name
+		if !x.opts.Required || parser.passedMap[name] {
+			continue
+		} else if _, ok := parser.lookup(name); ok {
+			continue
+		}
+		panic(fmt.Errorf("%w\nrequired keyword arg: %s\n", ErrNoArgs, name))
+	}
+
+	slices.SortFunc(parser.keywordsSlice, func(a, b *keyword) int {
+		if a.pos < b.pos {
+			return -1
+		}
+		return 1
+	})
+}
+
+// args returns the values following the switch up to argv[end], including
+// the value attached with `=` if any
+func (x *keyword) args(argv []string, end int) []string {
+	res := []string{}
+	if x.hasValue {
+		res = append(res, x.value)
+	}
+	return append(res, argv[x.pos+1:end]...)
+}
+
+// origin maps a position in the rewritten Argv, as used by Find and
+// Extract, to the index of the token in the args passed to the root parser
+func (parser *Parser) origin(pos int) int {
+	if pos < 0 || pos >= len(parser.origins) {
+		return pos
+	}
+	return parser.argvOffset + parser.origins[pos]
+}
+
+// positions returns the argv index of each value returned by args
+func (x *keyword) positions(end int) []int {
+	res := []int{}
+	if x.hasValue {
+		res = append(res, x.pos)
+	}
+	for i := x.pos + 1; i < end; i++ {
+		res = append(res, i)
+	}
+	return res
+}
+
+// envVar returns the env var the keyword falls back to: EnvVar, or the
+// name derived from EnvPrefix
+func (parser *Parser) envVar(x *keyword) string {
+	opts := x.opts
+	if opts.EnvVar != "" {
+		return opts.EnvVar
+	} else if parser.EnvPrefix == "" || x.name == parser.helpName || x.name == parser.versionName {
+		return ""
+	}
+
+	name := strings.ToUpper(strings.ReplaceAll(x.name, "-", "_"))
+	return parser.EnvPrefix + "_" + name
+}
+
+// envValue looks up the keyword's env var. A switch without values is passed
+// unless the variable is a false boolean
+func (parser *Parser) envValue(x *keyword) ([]string, bool) {
+	opts := x.opts
+	envVar := parser.envVar(x)
+	if envVar == "" {
+		return nil, false
+	}
+
+	value, ok := os.LookupEnv(envVar)
+	if !ok || (value == "" && !opts.AllowEmpty) {
+		return nil, false
+	}
+
+	if opts.N == 0 && opts.Nargs == "" && !opts.Negatable && !opts.Count {
+		if b, err := strconv.ParseBool(value); err == nil && !b {
+			return nil, false
+		}
+		return []string{}, true
+	}
+
+	return []string{value}, true
+}
+
+// checkCount checks the number of values passed to one occurrence of a
+// keyword against N/Nargs
+func (opts *Option) checkCount(gotten int) {
+	n := opts.N
+	nargs := opts.Nargs
+
+	// the value of negatable and count switches is set by Extract
+	if opts.Negatable || opts.Count {
+		return
+	}
+
+	if (n == 0 || nargs == "?" || nargs == "*") && gotten == 0 {
+		return
+	} else if n != -1 {
+		if n > gotten {
+			panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, opts))
+		} else if n < gotten {
+			panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, opts))
+		}
+		return
+	}
+
+	switch nargs {
+	case "+":
+		if gotten == 0 {
+			panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, opts))
+		}
+	case "?":
+		if gotten > 1 {
+			panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, opts))
+		}
+	}
+}
+
+// extractKeywords collects the values of each matched keyword. Values of
+// repeated keywords are merged in order. Values beyond what a keyword takes
+// are pushed onto tailArgv so they are read as positional args. Returns the
+// argv indices of those values
+func (parser *Parser) extractKeywords(argv []string) []int {
+	first := parser.keywordsSlice[0]
+	keywordsL := len(parser.keywordsSlice)
+	spilled := []string{}
+	spilledPos := []int{}
+
+	if first.pos != 0 {
+		parser.headArgv = argv[:first.pos]
+	}
+
+	collect := func(x *keyword, args []string, pos []int) {
+		limit := -1
+		if x.opts.N != -1 {
+			limit = x.opts.N
+		} else if x.opts.Nargs == "?" {
+			limit = 1
+		}
+
+		if limit != -1 && len(args) > limit {
+			spilled = append(spilled, args[limit:]...)
+			spilledPos = append(spilledPos, pos[limit:]...)
+			args = args[:limit]
+			pos = pos[:limit]
+		}
+
+		x.opts.checkCount(len(args))
+		if _, ok := parser.parsedMap[x.name]; !ok {
+			parser.parsedMap[x.name] = []string{}
+		}
+		parser.parsedMap[x.name] = append(parser.parsedMap[x.name], args...)
+		parser.positionsMap[x.name] = append(parser.positionsMap[x.name], pos...)
+
+		// the last of --x and --x=false wins. A switch turned off stays
+		// unset even if its env var or Default would set it
+		if x.off {
+			delete(parser.parsedMap, x.name)
+			delete(parser.positionsMap, x.name)
+			delete(parser.passedMap, x.name)
+			parser.offMap[x.name] = true
+		} else {
+			parser.passedMap[x.name] = true
+			delete(parser.offMap, x.name)
+		}
+	}
+
+	for i, current := range parser.keywordsSlice {
+		end := len(argv)
+		if i < keywordsL-1 {
+			end = parser.keywordsSlice[i+1].pos
+		}
+
+		// every keyword reads up to the next one, so + and * values are
+		// collected the same way wherever the keyword is. The last keyword
+		// only differs in also taking the values cut off by a greedy switch,
+		// which is always the last keyword when there are any
+		args := current.args(argv, end)
+		pos := current.positions(end)
+		if i == keywordsL-1 {
+			args = append(args, parser.greedyArgv...)
+			for j := range parser.greedyArgv {
+				pos = append(pos, end+j)
+			}
+		}
+		collect(current, args, pos)
+	}
+
+	parser.tailArgv = append(spilled, parser.tailArgv...)
+	return spilledPos
+}
+
+// Extract collects the values of the matched keywords and fills the
+// positional args. Positionals may be interspersed with keywords: they are
+// read in order from before the first keyword, from whatever keywords leave
+// over once they have their values, and from after `--`. So with a switch
+// --verbose, `a --verbose b -- c` passes a, b and c
+func (parser *Parser) Extract() {
+	argv := parser.Argv
+	spilledPos := []int{}
+	if len(parser.keywordsSlice) == 0 {
+		parser.headArgv = argv
+	} else {
+		spilledPos = parser.extractKeywords(argv)
+	}
+
+	counts := map[string]int{}
+	for _, x := range parser.keywordsSlice {
+		if x.opts.Negatable {
+			parser.parsedMap[x.name] = []string{strconv.FormatBool(!x.negated)}
+			parser.positionsMap[x.name] = []int{x.pos}
+		} else if x.opts.Count {
+			counts[x.name]++
+			parser.parsedMap[x.name] = []string{strconv.Itoa(counts[x.name])}
+			parser.positionsMap[x.name] = []int{x.pos}
+		}
+	}
+
+	// positions follow the order of allArgv: the head, the spilled values
+	// and then the args after the `--`
+	allPos := []int{}
+	for i := range parser.headArgv {
+		allPos = append(allPos, i)
+	}
+	allPos = append(allPos, spilledPos...)
+	tailStart := len(argv) + len(parser.greedyArgv) + 1
+	for i := len(spilledPos); i < len(parser.tailArgv); i++ {
+		allPos = append(allPos, tailStart+i-len(spilledPos))
+	}
+
+	parser.allArgv = append(parser.headArgv, parser.tailArgv...)
+	allArgvL := len(parser.allArgv)
+	argumentsSliceL := len(parser.argumentsSlice)
+
+	// the least number of values each positional arg needs, so that
+	// variadic args leave enough for the ones after them. Args with a
+	// Default need none
+	required := func(x *argument) bool {
+		return x.opts.Default == nil && (x.opts.Nargs == "" || x.opts.Nargs == "+")
+	}
+	needed := make([]int, argumentsSliceL+1)
+	for i := argumentsSliceL - 1; i >= 0; i-- {
+		needed[i] = needed[i+1]
+		if required(parser.argumentsSlice[i]) {
+			needed[i]++
+		}
+	}
+
+	pos := 0
+	for i, v := range parser.argumentsSlice {
+		// plain args are filled left to right and only variadic args hold
+		// values back for the args after them
+		available := allArgvL - pos
+		taken := 0
+		switch v.opts.Nargs {
+		case "":
+			taken = min(available, 1)
+		case "?":
+			taken = min(max(available-needed[i+1], 0), 1)
+		default:
+			taken = max(available-needed[i+1], 0)
+		}
+
+		var res []string
+		offset := 0
+		if taken > 0 {
+			res = slices.Clone(parser.allArgv[pos : pos+taken])
+			parser.positionsMap[v.name] = allPos[pos : pos+taken]
+			parser.passedMap[v.name] = true
+			if v.opts.AppendDefaults {
+				offset = len(v.opts.Default)
+				parser.parsedMap[v.name] = res
+				parser.prependDefaults(v.name, v.opts)
+				res = parser.parsedMap[v.name]
+			}
+		} else if value, ok := parser.lookup(v.name); ok {
+			nargs := v.opts.Nargs
+			if (nargs == "" || nargs == "+") && len(value) == 0 {
+				panic(fmt.Errorf("%w\nargument: %s\nreason: no values in source\n", ErrLessArgs, v.name))
+			} else if (nargs == "" || nargs == "?") && len(value) > 1 {
+				panic(fmt.Errorf("%w\nargument: %s\nreason: expected 1 value, got %d\n", ErrExcessArgs, v.name, len(value)))
+			}
+			res = value
+		} else if v.opts.Default != nil {
+			res = slices.Clone(v.opts.Default)
+			parser.defaultedMap[v.name] = true
+		} else if v.opts.Nargs == "" || v.opts.Nargs == "+" {
+			// the args left over go to the required args right after this
+			// one, so the ones at the end get nothing
+			later := []string{}
+			for _, x := range parser.argumentsSlice[i+1:] {
+				if required(x) {
+					later = append(later, x.name)
+				}
+			}
+			missing := append([]string{v.name}, later[min(available, len(later)):]...)
+
+			panic(fmt.Errorf(
+				"%w\nmissing: %s\nreason: expected %d args, got %d\n%s\n",
+				ErrLessArgs,
+				strings.Join(missing, ", "),
+				needed[0],
+				allArgvL,
+				parser.genHeader(),
+			))
+		} else {
+			// an optional arg that got nothing is left empty, but counts as
+			// defaulted so that it neither satisfies nor trips Requires,
+			// Excludes or the one-of groups
+			res = []string{}
+			parser.defaultedMap[v.name] = true
+		}
+		parser.parsedMap[v.name] = res
+
+		// numeric keys share the values so that Map applies to them too
+		for j := offset; j < offset+taken; j++ {
+			parser.parsedMap[strconv.Itoa(pos+j-offset)] = res[j : j+1 : j+1]
+		}
+		pos += taken
+	}
+
+	for i := pos; i < allArgvL; i++ {
+		name := strconv.Itoa(i)
+		parser.parsedMap[name] = []string{parser.allArgv[i]}
+	}
+
+	// positional args are filled before Rest, so drop whatever they took
+	// from after the `--`
+	consumed := pos - (allArgvL - len(parser.restArgv))
+	if consumed > 0 {
+		parser.restArgv = parser.restArgv[consumed:]
+	}
+
+	for name, x := range parser.keywordsMap {
+		if _, ok := parser.parsedMap[name]; ok || parser.offMap[name] {
+			continue
+		} else if value, ok := parser.lookup(name); ok {
+			x.opts.checkCount(len(value))
+			parser.parsedMap[name] = value
+		}
+	}
+
+	for name, x := range parser.keywordsMap {
+		if _, ok := parser.parsedMap[name]; ok && x.opts.AppendDefaults {
+			parser.prependDefaults(name, x.opts)
+			continue
+		} else if ok || parser.offMap[name] || x.opts.Required || x.opts.Default == nil {
+			continue
+		}
+		parser.parsedMap[name] = slices.Clone(x.opts.Default)
+		parser.defaultedMap[name] = true
+	}
+
+	// report positions in the args as they were passed
+	for name, xs := range parser.positionsMap {
+		pos := make([]int, len(xs))
+		for i, x := range xs {
+			pos[i] = parser.origin(x)
+		}
+		parser.positionsMap[name] = pos
+	}
+}
+
+// prependDefaults puts the Default values before the values of name
+func (parser *Parser) prependDefaults(name string, opts *Option) {
+	pos := []int{}
+	for range opts.Default {
+		pos = append(pos, -1)
+	}
+
+	parser.parsedMap[name] = append(slices.Clone(opts.Default), parser.parsedMap[name]...)
+	if xs, ok := parser.positionsMap[name]; ok {
+		parser.positionsMap[name] = append(pos, xs...)
+	}
+}
+
+func splitValues(xs []string, sep string) []string {
+	res := []string{}
+	for _, x := range xs {
+		for _, field := range strings.Split(x, sep) {
+			if field != "" {
+				res = append(res, field)
+			}
+		}
+	}
+	return res
+}
+
+// options returns the options of the keyword or positional arg name
+func (parser *Parser) options(name string) *Option {
+	if x, ok := parser.keywordsMap[name]; ok {
+		return x.opts
+	} else if x, ok := parser.argumentsMap[name]; ok {
+		return x.opts
+	}
+	return nil
+}
+
+// nameType names the kind of option name is for error messages
+func (parser *Parser) nameType(name string) string {
+	if _, ok := parser.argumentsMap[name]; ok {
+		return "argument"
+	}
+	return "keyword"
+}
+
+// where describes the token the i-th value of name was read from, for
+// error messages. Empty when the value did not come from argv
+func (parser *Parser) where(name string, i int) string {
+	pos := parser.positionsMap[name]
+	xs := parser.parsedMap[name]
+	if len(pos) != len(xs) || i >= len(pos) || pos[i] < 0 {
+		return ""
+	}
+	return fmt.Sprintf("token: %q\nposition: %d\n", xs[i], pos[i])
+}
+
+func (parser *Parser) Validate() {
+	for _, name := range parser.namesOrder {
+		opts := parser.options(name)
+		if opts.Deprecated != "" && parser.passedMap[name] {
+			fmt.Fprintf(parser.errorOutput(), "warning: %s\n", opts.Deprecated)
+		}
+	}
+
+	checkAssert := func(name, nameType string, assert func(s string) error, xs []string) {
+		if assert == nil {
+			return
+		}
+
+		for i, x := range xs {
+			if err := assert(x); err != nil {
+				panic(fmt.Errorf(
+					"%w\nAssertion failure for %s [%s]\nreason: %v\n%s",
+					ErrAssertionFailure,
+					name,
+					nameType,
+					err,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkEnum := func(name, nameType string, opts *Option, xs []string) {
+		enum := opts.Enum
+		if enum == nil {
+			return
+		}
+
+		for i, x := range xs {
+			found := slices.Index(enum, x)
+			if found == -1 && opts.EnumCaseInsensitive {
+				found = slices.IndexFunc(enum, func(choice string) bool {
+					return strings.EqualFold(choice, x)
+				})
+				if found != -1 {
+					xs[i] = enum[found]
+				}
+			}
+
+			if found == -1 {
+				panic(fmt.Errorf(
+					"%w\nChoices: %s\nGiven: %s\n%s [%s]\n%s",
+					ErrInvalidChoice,
+					strings.Join(enum, ","),
+					strings.Join(xs, ","),
+					name,
+					nameType,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkType := func(name, nameType string, t Type, xs []string) {
+		for i, x := range xs {
+			if err := t.check(x); err != nil {
+				panic(fmt.Errorf(
+					"%w\nExpected %s for %s [%s], got %q\n%s",
+					ErrInvalidType,
+					t,
+					name,
+					nameType,
+					x,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkRange := func(name, nameType string, opts *Option, xs []string) {
+		if opts.Min == nil && opts.Max == nil {
+			return
+		}
+
+		for i, x := range xs {
+			f, err := strconv.ParseFloat(x, 64)
+			if err != nil {
+				panic(fmt.Errorf(
+					"%w\nExpected a number for %s [%s], got %q\n%s",
+					ErrInvalidType,
+					name,
+					nameType,
+					x,
+					parser.where(name, i),
+				))
+			}
+
+			bound := ""
+			if opts.Min != nil && f < *opts.Min {
+				bound = fmt.Sprintf("min: %v", *opts.Min)
+			} else if opts.Max != nil && f > *opts.Max {
+				bound = fmt.Sprintf("max: %v", *opts.Max)
+			}
+
+			if bound != "" {
+				panic(fmt.Errorf(
+					"%w\n%s out of range for %s [%s]\n%s\n%s",
+					ErrAssertionFailure,
+					x,
+					name,
+					nameType,
+					bound,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkPattern := func(name, nameType string, opts *Option, xs []string) {
+		var re *regexp.Regexp
+		if x, ok := parser.keywordsMap[name]; ok {
+			re = x.pattern
+		} else if x, ok := parser.argumentsMap[name]; ok {
+			re = x.pattern
+		}
+
+		if re == nil {
+			return
+		}
+
+		for i, x := range xs {
+			if !re.MatchString(x) {
+				panic(fmt.Errorf(
+					"%w\n%q does not match %s for %s [%s]\n%s",
+					ErrAssertionFailure,
+					x,
+					opts.Pattern,
+					name,
+					nameType,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkDeps := func(name, nameType string, deps []string) {
+		missing := []string{}
+		for _, dep := range deps {
+			if _, ok := parser.parsedMap[dep]; !ok || parser.defaultedMap[dep] {
+				missing = append(missing, dep)
+			}
+		}
+
+		if len(missing) > 0 {
+			panic(fmt.Errorf(
+				"%w\n%s [%s] requires: %s\n",
+				ErrMissingDeps,
+				name,
+				nameType,
+				strings.Join(missing, ","),
+			))
+		}
+	}
+
+	checkExcludes := func(name, nameType string, excludes []string) {
+		for _, other := range excludes {
+			if other == name {
+				continue
+			}
+
+			if _, ok := parser.parsedMap[other]; ok && !parser.defaultedMap[other] {
+				panic(fmt.Errorf(
+					"%w\n%s [%s] cannot be used with %s\n",
+					ErrUnallowedDeps,
+					name,
+					nameType,
+					other,
+				))
+			}
+		}
+	}
+
+	// exclusion is symmetric: declaring it on either option is enough
+	excludesMap := map[string][]string{}
+	addExcludes := func(name string, excludes []string) {
+		for _, other := range excludes {
+			excludesMap[name] = append(excludesMap[name], other)
+			excludesMap[other] = append(excludesMap[other], name)
+		}
+	}
+
+	for name, x := range parser.keywordsMap {
+		addExcludes(name, x.opts.Excludes)
+	}
+
+	for name, x := range parser.argumentsMap {
+		addExcludes(name, x.opts.Excludes)
+	}
+
+	for name := range parser.parsedMap {
+		if parser.defaultedMap[name] {
+			continue
+		}
+
+		if x, ok := parser.keywordsMap[name]; ok {
+			checkDeps(name, "keyword", x.opts.Requires)
+			checkExcludes(name, "keyword", excludesMap[name])
+		} else if x, ok := parser.argumentsMap[name]; ok {
+			checkDeps(name, "argument", x.opts.Requires)
+			checkExcludes(name, "argument", excludesMap[name])
+		}
+	}
+
+	for _, group := range parser.oneOf {
+		given := []string{}
+		for _, name := range group.names {
+			if _, ok := parser.parsedMap[name]; ok && !parser.defaultedMap[name] {
+				given = append(given, name)
+			}
+		}
+
+		if group.atLeastOne && len(given) == 0 {
+			panic(fmt.Errorf(
+				"%w\nreason: expected one of %s\n",
+				ErrGroupConstraint,
+				strings.Join(group.names, ", "),
+			))
+		} else if group.atMostOne && len(given) > 1 {
+			panic(fmt.Errorf(
+				"%w\nreason: %s cannot be used together\n",
+				ErrGroupConstraint,
+				strings.Join(given, ", "),
+			))
+		}
+	}
+
+	checkFileRef := func(name, nameType string, xs []string) {
+		for i, x := range xs {
+			if strings.HasPrefix(x, "@@") {
+				xs[i] = x[1:]
+				continue
+			} else if !strings.HasPrefix(x, "@") {
+				continue
+			}
+
+			contents, err := os.ReadFile(x[1:])
+			if err != nil {
+				panic(fmt.Errorf(
+					"%w\n%s [%s]\nreason: %v\n",
+					ErrFileRef,
+					name,
+					nameType,
+					err,
+				))
+			}
+			xs[i] = string(contents)
+		}
+	}
+
+	// checkStdin replaces the - values of options with AllowStdin. Only the
+	// first - in argv order gets stdin. Values not read from argv come last
+	checkStdin := func() {
+		type stdinValue struct {
+			name string
+			i    int
+			pos  int
+		}
+
+		values := []stdinValue{}
+		for _, name := range parser.namesOrder {
+			opts := parser.options(name)
+			xs := parser.parsedMap[name]
+			positions := parser.positionsMap[name]
+			if !opts.AllowStdin {
+				continue
+			}
+
+			for i, x := range xs {
+				pos := parser.argvOffset + len(parser.inputArgv) + len(parser.inputTailArgv) + 1
+				if len(positions) == len(xs) && positions[i] >= 0 {
+					pos = positions[i]
+				}
+				if x == "-" {
+					values = append(values, stdinValue{name, i, pos})
+				}
+			}
+		}
+
+		slices.SortStableFunc(values, func(a, b stdinValue) int {
+			return a.pos - b.pos
+		})
+
+		for _, x := range values {
+			nameType := parser.nameType(x.name)
+			if parser.stdinRead {
+				panic(fmt.Errorf(
+					"%w\n%s [%s]\nreason: stdin has already been consumed\n%s",
+					ErrStdin,
+					x.name,
+					nameType,
+					parser.where(x.name, x.i),
+				))
+			}
+
+			contents, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				panic(fmt.Errorf(
+					"%w\n%s [%s]\nreason: %v\n",
+					ErrStdin,
+					x.name,
+					nameType,
+					err,
+				))
+			}
+			parser.parsedMap[x.name][x.i] = string(contents)
+			parser.stdinRead = true
+		}
+	}
+
+	checkKeyValue := func(name, nameType string, xs []string) {
+		for i, x := range xs {
+			if !strings.Contains(x, "=") {
+				panic(fmt.Errorf(
+					"%w\n%s [%s]\nvalue: %s\nreason: expected key=value\n%s",
+					ErrInvalidValue,
+					name,
+					nameType,
+					x,
+					parser.where(name, i),
+				))
+			}
+		}
+	}
+
+	checkEmpty := func(name, nameType string, args []string) {
+		for i, x := range args {
+			if x == "" {
+				panic(fmt.Errorf("%w\n%s [%s]\n%s", ErrEmptyValue, name, nameType, parser.where(name, i)))
+			}
+		}
+	}
+
+	mapErr := func(name, nameType string, f func(s string) (string, error)) {
+		for i, v := range parser.parsedMap[name] {
+			mapped, err := f(v)
+			if err != nil {
+				panic(fmt.Errorf(
+					"%w\n%s [%s]\nvalue: %s\nreason: %v\n%s",
+					ErrMapFailure,
+					name,
+					nameType,
+					v,
+					err,
+					parser.where(name, i),
+				))
+			}
+			parser.parsedMap[name][i] = mapped
+		}
+	}
+
+	check := func(name, nameType string, opts *Option, args []string) {
+		if opts.AllowFileRef {
+			checkFileRef(name, nameType, args)
+		}
+
+		if opts.Split != "" {
+			args = splitValues(args, opts.Split)
+			parser.parsedMap[name] = args
+			delete(parser.positionsMap, name)
+		}
+
+		if opts.KeyValue {
+			checkKeyValue(name, nameType, args)
+		}
+
+		checkType(name, nameType, opts.Type, args)
+		checkRange(name, nameType, opts, args)
+		checkEnum(name, nameType, opts, args)
+		checkPattern(name, nameType, opts, args)
+		checkAssert(name, nameType, opts.Assert, args)
+
+		if opts.AssertNamed != nil {
+			checkAssert(name, nameType, func(s string) error {
+				return opts.AssertNamed(name, s)
+			}, args)
+		}
+
+		if opts.AssertCtx != nil {
+			ctx := parser.context()
+			checkAssert(name, nameType, func(s string) error {
+				parser.checkContext(name, nameType)
+				err := opts.AssertCtx(ctx, s)
+				parser.checkContext(name, nameType)
+				return err
+			}, args)
+		}
+
+		// defaults are taken to be in their final form already
+		if parser.defaultedMap[name] && !opts.MapDefaults {
+			return
+		}
+
+		if opts.Map != nil {
+			for i, v := range args {
+				parser.parsedMap[name][i] = opts.Map(v)
+			}
+		}
+
+		if opts.MapErr != nil {
+			mapErr(name, nameType, opts.MapErr)
+		}
+
+		if opts.MapCtx != nil {
+			ctx := parser.context()
+			mapErr(name, nameType, func(s string) (string, error) {
+				parser.checkContext(name, nameType)
+				res, err := opts.MapCtx(ctx, s)
+				parser.checkContext(name, nameType)
+				return res, err
+			})
+		}
+	}
+
+	// empty values are looked for before - is replaced by stdin, which
+	// may well be empty
+	for name, args := range parser.parsedMap {
+		if opts := parser.options(name); opts != nil && !opts.AllowEmpty && !parser.defaultedMap[name] {
+			checkEmpty(name, parser.nameType(name), args)
+		}
+	}
+
+	checkStdin()
+
+	for name, args := range parser.parsedMap {
+		if x, ok := parser.keywordsMap[name]; ok {
+			check(name, "keyword", x.opts, args)
+		} else if x, ok := parser.argumentsMap[name]; ok {
+			check(name, "argument", x.opts, args)
+		}
+	}
+
+	if parser.PostValidate != nil {
+		if err := parser.PostValidate(parser.parsedMap); err != nil {
+			panic(fmt.Errorf("%w\nreason: %w\n", ErrValidation, err))
+		}
+	}
+
+	for _, name := range parser.namesOrder {
+		opts := parser.options(name)
+		nameType := parser.nameType(name)
+
+		values, ok := parser.parsedMap[name]
+		if !ok || opts.OnSet == nil {
+			continue
+		} else if err := opts.OnSet(values); err != nil {
+			panic(fmt.Errorf("%w\n%s [%s]\nreason: %w\n", ErrOnSet, name, nameType, err))
+		}
+	}
+}
+
+func (parser *Parser) parse() map[string][]string {
+	// parsing works on Argv in place, so keep the input for Reset
+	if !parser.inputSaved {
+		parser.inputArgv = slices.Clone(parser.Argv)
+		parser.inputTailArgv = slices.Clone(parser.tailArgv)
+		parser.inputSaved = true
 	}
 
-	nargs := &opts.Nargs
-	if *nargs != "" {
-		if nargsRe.FindStringIndex(*nargs) == nil {
-			panic(fmt.Errorf("%w\nOption: %#v\n", ErrInvalidNargs, opts))
-		}
-		opts.N = -1
-	}
+	sub := parser.dispatch()
 
-	keywordsMap[opts.Name] = &keyword{
-		name:  opts.Name,
-		pos:   -1,
-		value: "",
-		opts:  opts,
+	// completion runs before the parent's checks so that it works even
+	// with required options missing
+	if sub != nil && sub.name == parser.completionName && parser.stopOnHelp() {
+		sub.parser.parse()
+		shell := sub.parser.parsedMap["shell"][0]
+		fmt.Fprint(parser.output(), parser.completion(shell))
+		parser.helpDone(ErrHelpRequested)
 	}
 
-	return parser
-}
+	parser.Find()
+	parser.Extract()
 
-func (parser *Parser) Find() {
-	exitOnHelp := parser.ExitOnHelp
-	argv := parser.Argv
+	// without positional args, a positional before `--` can only be a
+	// mistyped subcommand
+	if sub == nil && len(parser.subcommands) > 0 && len(parser.argumentsSlice) == 0 &&
+		len(parser.allArgv) > len(parser.restArgv) {
+		parser.unknownCommand(parser.allArgv[0])
+	}
 
-	matches := func(prefix string, a string, b string) bool {
-		return (prefix + a) == b
+	parser.Validate()
+
+	if sub != nil {
+		sub.parser.ctx = parser.ctx
+		sub.parser.parse()
+		parser.parsedMap[SubcommandKey] = []string{sub.name}
 	}
 
-	find := func(x *keyword) {
-		opts := x.opts
-		dup := opts.AllowDuplicates
-		req := opts.Required
+	parser.Parsed = parser.parsedMap
+	parser.populate()
 
-		for i, v := range argv {
-			matched := -1
-			if opts.ShortName != "" && matches("-", opts.ShortName, v) {
-				if v == "-h" && exitOnHelp {
-					fmt.Println(parser.genHeader())
-					os.Exit(0)
-				}
-				matched = i
-			}
+	return parser.parsedMap
+}
 
-			if matched == -1 && matches("--", opts.LongName, v) {
-				if v == "--help" && exitOnHelp {
-					fmt.Println(parser.genHelp())
-					os.Exit(0)
-				}
-				matched = i
-			}
+// ParseContext is like ParseErr but passes ctx to AssertCtx and MapCtx. The
+// parse stops with ctx's error once ctx is done
+func (parser *Parser) ParseContext(ctx context.Context) (map[string][]string, error) {
+	parser.ctx = ctx
+	defer func() {
+		parser.ctx = nil
+	}()
 
-			if matched == -1 && req {
-				panic(fmt.Errorf("%w\nkeyword arg: %#v\n", ErrNoArgs, x))
-			}
+	return parser.ParseErr()
+}
 
-			if matched != -1 {
-				y := *x
-				y.pos = i
-				keywordsSlice = append(keywordsSlice, &y)
-				if checkDups[opts.Name] && !dup {
-					panic(fmt.Errorf("%w\nkeyword arg: %#v\n", ErrDuplicate, x))
-				} else {
-					checkDups[opts.Name] = true
-				}
-			}
-		}
+func (parser *Parser) context() context.Context {
+	if parser.ctx == nil {
+		return context.Background()
 	}
+	return parser.ctx
+}
 
-	for _, v := range keywordsMap {
-		find(v)
+// checkContext aborts the parse once the context is done
+func (parser *Parser) checkContext(name, nameType string) {
+	if err := parser.context().Err(); err != nil {
+		panic(fmt.Errorf("%w\n%s [%s]\n", err, name, nameType))
 	}
+}
 
-	slices.SortFunc(keywordsSlice, func(a, b *keyword) int {
-		if a.pos < b.pos {
-			return -1
-		}
-		return 1
-	})
+// Parse panics on parse errors unless ExitOnError is set. Without
+// ExitOnHelp, help and version are matched like any other switch
+func (parser *Parser) Parse() map[string][]string {
+	parsed, err := parser.parseErr(false)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
 }
 
-func (parser *Parser) Extract() {
-	argv := parser.Argv
-	first := keywordsSlice[0]
-	keywordsL := len(keywordsSlice)
-	last := keywordsSlice[keywordsL-1]
+// ParseErr is like Parse but returns parse errors instead of panicking.
+// With ExitOnError set, the error and usage are written to ErrorWriter and
+// the program exits with ExitCode. Without ExitOnHelp, passing help or
+// version returns ErrHelpRequested or ErrVersionRequested after writing
+// the output
+func (parser *Parser) ParseErr() (parsed map[string][]string, err error) {
+	return parser.parseErr(true)
+}
 
-	if first.pos != 0 {
-		headArgv = argv[:first.pos]
-	}
+func (parser *Parser) parseErr(helpErrors bool) (parsed map[string][]string, err error) {
+	parser.helpErrors = helpErrors
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
 
-	for i := 0; i < keywordsL-1; i++ {
-		current := keywordsSlice[i]
-		next := keywordsSlice[i+1]
+		e, ok := r.(error)
+		if _, isRuntime := r.(runtime.Error); !ok || isRuntime {
+			panic(r)
+		}
 
-		if _, ok := parsedMap[current.name]; !ok {
-			parsedMap[current.name] = []string{}
+		helpDone := errors.Is(e, ErrHelpRequested) || errors.Is(e, ErrVersionRequested)
+		if parser.ExitOnError && !helpDone {
+			parser.exitWithError(e)
 		}
+		err = e
+	}()
+
+	return parser.parse(), nil
+}
 
-		res := append(parsedMap[current.name], argv[current.pos+1:next.pos]...)
-		parsedMap[current.name] = res
+func (parser *Parser) errorOutput() io.Writer {
+	if parser.ErrorWriter == nil {
+		return os.Stderr
 	}
+	return parser.ErrorWriter
+}
 
-	parsedMap[last.name] = argv[last.pos+1:]
-	lastArgs := parsedMap[last.name]
-	lastArgsL := len(lastArgs)
-	lastNargs := last.opts.Nargs
-	lastN := last.opts.N
+func (parser *Parser) exitWithError(err error) {
+	w := parser.errorOutput()
 
-	if lastN != -1 {
-		if lastArgsL > lastN {
-			parsedMap[last.name] = argv[last.pos+1 : last.pos+lastN+1]
-			tailArgv = append(argv[last.pos+lastN:], tailArgv...)
-		} else if lastN == 0 {
-			if lastArgsL > 0 {
-				panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, last.opts))
-			}
-		} else if lastN > lastArgsL {
-			panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, last.opts))
-		}
+	code := parser.ExitCode
+	if code == 0 {
+		code = 2
+	}
+
+	if parser.ErrorFormatter != nil {
+		fmt.Fprint(w, parser.ErrorFormatter(err))
 	} else {
-		switch lastNargs {
-		case "+":
-			if lastArgsL == 0 {
-				panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, last.opts))
-			}
-		case "?":
-			if lastArgsL > 1 {
-				panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, last.opts))
-			}
-		}
+		fmt.Fprintln(w, parser.genHeader())
+		fmt.Fprintf(w, "error: %v\n", err)
 	}
+	os.Exit(code)
+}
 
-	allArgv = append(headArgv, tailArgv...)
-	allArgvL := len(allArgv)
-	argumentsSliceL := len(argumentsSlice)
+// Rest returns the args passed after `--` that were not assigned to
+// registered positional args. Positional args are filled first, in order,
+// from the args before and after `--`, so the two never overlap. Only the
+// first `--` is a separator, so `a -- b -- c` leaves b, -- and c
+func (parser *Parser) Rest() []string {
+	return parser.restArgv
+}
 
-	if allArgvL < argumentsSliceL {
-		panic(fmt.Errorf("%w\nreason: expected %d args, got %d\n", ErrLessArgs, argumentsSliceL, allArgvL))
-	}
+// IsSet reports whether name was passed on the command line, as opposed to
+// being absent or filled in from the environment or a default
+func (parser *Parser) IsSet(name string) bool {
+	return parser.passedMap[name]
+}
+
+// JSON marshals Parsed
+func (parser *Parser) JSON() ([]byte, error) {
+	return json.Marshal(parser.Parsed)
+}
 
-	for i, v := range argumentsSlice {
-		res := []string{allArgv[i]}
-		parsedMap[v.name] = res
-		parsedMap[strconv.Itoa(i)] = res
+func (parser *Parser) values(name string) ([]string, error) {
+	xs, ok := parser.Parsed[name]
+	if !ok {
+		return nil, fmt.Errorf("%w\noption: %s\n", ErrNotPassed, name)
 	}
+	return xs, nil
+}
 
-	for i := argumentsSliceL; i < allArgvL; i++ {
-		name := strconv.Itoa(i)
-		parsedMap[name] = []string{argv[i]}
+// GetFirst returns the first value of name and whether name is in Parsed.
+// The value is "" if name has no values, as for a switch
+func (parser *Parser) GetFirst(name string) (string, bool) {
+	xs, ok := parser.Parsed[name]
+	if len(xs) == 0 {
+		return "", ok
 	}
+	return xs[0], ok
 }
 
-func (parser *Parser) Validate() {
-	last := keywordsSlice[len(keywordsSlice)-1]
+// GetAll returns a copy of the values of name, or nil if name is not in
+// Parsed
+func (parser *Parser) GetAll(name string) []string {
+	return slices.Clone(parser.Parsed[name])
+}
 
-	checkAssert := func(name, nameType string, assert func(s string) error, xs []string) {
-		if assert == nil {
-			return
-		}
+// Value returns the value of name shaped by its arity: a string for
+// options taking at most one value (N <= 1 and no Nargs), such as switches
+// and plain positional args, and a []string for the rest. A switch passed
+// without a value gives "" and one turned off with --name=false gives
+// "false". Returns nil if name is not in Parsed
+func (parser *Parser) Value(name string) interface{} {
+	opts := parser.options(name)
+	xs, ok := parser.Parsed[name]
+	if !ok && parser.offMap[name] {
+		return "false"
+	} else if !ok || opts == nil {
+		return nil
+	} else if opts.N > 1 || opts.Nargs != "" {
+		return slices.Clone(xs)
+	}
 
-		for _, x := range xs {
-			if err := assert(x); err != nil {
-				panic(fmt.Errorf(
-					"%w\nAssertion failure for %s [%s]\n",
-					ErrAssertionFailure,
-					name,
-					nameType,
-				))
-			}
-		}
+	x, _ := parser.GetFirst(name)
+	return x
+}
+
+func (parser *Parser) GetString(name string) (string, error) {
+	xs, err := parser.values(name)
+	if err != nil {
+		return "", err
+	} else if len(xs) == 0 {
+		return "", fmt.Errorf("%w\noption: %s\nreason: no values\n", ErrInvalidValue, name)
 	}
+	return xs[0], nil
+}
 
-	checkEnum := func(name, nameType string, enum, xs []string) {
-		if enum == nil {
-			return
-		}
+func (parser *Parser) GetInt(name string) (int, error) {
+	s, err := parser.GetString(name)
+	if err != nil {
+		return 0, err
+	}
 
-		for _, x := range xs {
-			if slices.Index(enum, x) == -1 {
-				panic(fmt.Sprintf(
-					"%v\nChoices: %s\nGiven: %s\n%s [%s]\n",
-					ErrInvalidChoice,
-					strings.Join(enum, ","),
-					strings.Join(xs, ","),
-					name,
-					nameType,
-				))
-			}
-		}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: %v\n", ErrInvalidValue, name, s, err)
+	}
+	return n, nil
+}
+
+func (parser *Parser) GetInts(name string) ([]int, error) {
+	xs, err := parser.values(name)
+	if err != nil {
+		return nil, err
 	}
 
-	for name, args := range parsedMap {
-		if name == last.name {
-			continue
+	res := make([]int, len(xs))
+	for i, s := range xs {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: %v\n", ErrInvalidValue, name, s, err)
 		}
+		res[i] = n
+	}
+	return res, nil
+}
 
-		var keywordX *keyword
-		var argX *argument
+func (parser *Parser) GetFloat(name string) (float64, error) {
+	s, err := parser.GetString(name)
+	if err != nil {
+		return 0, err
+	}
 
-		if x, ok := keywordsMap[name]; ok {
-			keywordX = x
-		} else if x, ok := argumentsMap[name]; ok {
-			argX = x
-		}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: %v\n", ErrInvalidValue, name, s, err)
+	}
+	return n, nil
+}
 
-		if keywordX != nil {
-			opts := keywordX.opts
-			n := opts.N
-			nargs := opts.Nargs
-			gotten := len(args)
+func (parser *Parser) GetDuration(name string) (time.Duration, error) {
+	s, err := parser.GetString(name)
+	if err != nil {
+		return 0, err
+	}
 
-			if (n == 0 || nargs == "?" || nargs == "*") && gotten == 0 {
-				return
-			} else if n != -1 {
-				if n > gotten {
-					panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, opts))
-				} else if n < gotten {
-					panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, opts))
-				}
-				return
-			}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: %v\n", ErrInvalidValue, name, s, err)
+	}
+	return d, nil
+}
 
-			switch nargs {
-			case "+":
-				if gotten == 0 {
-					panic(fmt.Errorf("%w\nswitch: %#v\n", ErrLessArgs, opts))
-				}
-			case "?":
-				if gotten > 1 {
-					panic(fmt.Errorf("%w\nswitch: %#v\n", ErrExcessArgs, opts))
-				}
-			}
+// GetBool returns true for a switch passed without any values and false for
+// one turned off with --name=false. Otherwise the first value is parsed with
+// strconv.ParseBool, as for env and config values. Fails with ErrNotPassed
+// when name has no value at all
+func (parser *Parser) GetBool(name string) (bool, error) {
+	xs, err := parser.values(name)
+	if err != nil && parser.offMap[name] {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	} else if len(xs) == 0 {
+		return true, nil
+	}
 
-			checkEnum(name, "keyword", opts.Enum, args)
-			checkAssert(name, "keyword", opts.Assert, args)
+	b, err := strconv.ParseBool(xs[0])
+	if err != nil {
+		return false, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: %v\n", ErrInvalidValue, name, xs[0], err)
+	}
+	return b, nil
+}
 
-			if opts.Map != nil {
-				for i, v := range args {
-					parsedMap[name][i] = opts.Map(v)
-				}
-			}
-		}
+// GetMap returns the key=value pairs passed to name. Later pairs override
+// earlier ones with the same key
+func (parser *Parser) GetMap(name string) (map[string]string, error) {
+	xs, err := parser.values(name)
+	if err != nil {
+		return nil, err
+	}
 
-		argx, ok := argumentsMap[name]
+	res := map[string]string{}
+	for _, x := range xs {
+		k, v, ok := strings.Cut(x, "=")
 		if !ok {
-			continue
+			return nil, fmt.Errorf("%w\noption: %s\nvalue: %s\nreason: expected key=value\n", ErrInvalidValue, name, x)
 		}
+		res[k] = v
+	}
+	return res, nil
+}
 
-		argX = argx
-		opts := argX.opts
-		checkEnum(name, "argument", argX.opts.Enum, args)
-		checkAssert(name, "argument", argX.opts.Assert, args)
+// wrap word-wraps text at width. Every line after the first is indented by
+// indent spaces and the first line is assumed to start at column indent
+func wrap(text string, indent, width int) string {
+	res := strings.Builder{}
+	ws := strings.Repeat(" ", indent)
+	col := indent
 
-		if opts.Map != nil {
-			for i, v := range args {
-				parsedMap[name][i] = opts.Map(v)
-			}
+	for i, word := range strings.Fields(text) {
+		if i > 0 && col+1+len(word) > width {
+			res.WriteString("\n")
+			res.WriteString(ws)
+			col = indent
+		} else if i > 0 {
+			res.WriteString(" ")
+			col++
 		}
-	}
-}
 
-func (parser *Parser) Parse() map[string][]string {
-	parser.Find()
-	parser.Extract()
-	parser.Validate()
-	parser.Parsed = parsedMap
+		res.WriteString(word)
+		col += len(word)
+	}
 
-	return parsedMap
+	return res.String()
 }
 
-func sentenceLen(x []string) int {
-	n := 0
-	for _, v := range x {
-		n += len(v)
+// maxChoicesWidth is how long the list of Enum values shown in headers can
+// get before the rest is replaced by ...
+const maxChoicesWidth = 30
+
+// choices joins the Enum values with | for use as a metavar
+func (opts *Option) choices() string {
+	res := ""
+	for i, x := range opts.Enum {
+		if i > 0 && len(res)+len(x)+1 > maxChoicesWidth {
+			return res + "|..."
+		} else if i > 0 {
+			res += "|"
+		}
+		res += x
 	}
-	return n
+	return res
 }
 
 func (S *keyword) genHeader(useLong bool, addRequiredHint bool) string {
@@ -451,6 +2675,10 @@ func (S *keyword) genHeader(useLong bool, addRequiredHint bool) string {
 	nargs := opts.Nargs
 	n := opts.N
 
+	if opts.Negatable && long != "" {
+		long = "[no-]" + long
+	}
+
 	push := func(s string) {
 		header = append(header, s)
 	}
@@ -465,11 +2693,19 @@ func (S *keyword) genHeader(useLong bool, addRequiredHint bool) string {
 		push("--" + long)
 	}
 
+	if useLong {
+		for _, alias := range opts.Aliases {
+			header[len(header)-1] += ", --" + alias
+		}
+	}
+
 	if addRequiredHint && !opts.Required {
 		header[len(header)-1] += "?"
 	}
 
-	if mvar == "" {
+	if mvar == "" && opts.Enum != nil {
+		mvar = opts.choices()
+	} else if mvar == "" {
 		if short != "" {
 			mvar = strings.ToUpper(short)
 		} else {
@@ -487,8 +2723,15 @@ func (S *keyword) genHeader(useLong bool, addRequiredHint bool) string {
 			push(fmt.Sprintf("{%s,...}", mvar))
 		}
 	} else if n > 0 {
+		// space separated metavars name each value, repeating the last
+		mvars := strings.Fields(mvar)
 		if n == 1 {
 			push(fmt.Sprintf("{%s}", mvar))
+		} else if len(mvars) > 1 {
+			for len(mvars) < n {
+				mvars = append(mvars, mvars[len(mvars)-1])
+			}
+			push(fmt.Sprintf("{%s}", strings.Join(mvars[:n], " ")))
 		} else {
 			push(fmt.Sprintf("{%s<%d>}", mvar, n))
 		}
@@ -499,19 +2742,122 @@ func (S *keyword) genHeader(useLong bool, addRequiredHint bool) string {
 
 func (x *argument) genHeader() string {
 	mvar := x.opts.Metavar
-	if mvar == "" {
+	if mvar == "" && x.opts.Enum != nil {
+		mvar = "{" + x.opts.choices() + "}"
+	} else if mvar == "" {
 		mvar = strings.ToUpper(x.name)
 	}
+
+	switch x.opts.Nargs {
+	case "?":
+		return fmt.Sprintf("[%s]", mvar)
+	case "*":
+		return fmt.Sprintf("[%s...]", mvar)
+	case "+":
+		return fmt.Sprintf("%s...", mvar)
+	}
 	return fmt.Sprintf("%s", mvar)
 }
 
+func (parser *Parser) output() io.Writer {
+	if parser.Output == nil {
+		return os.Stdout
+	}
+	return parser.Output
+}
+
+// WriteUsage writes the usage line to w
+func (parser *Parser) WriteUsage(w io.Writer) {
+	fmt.Fprintln(w, parser.genHeader())
+}
+
+// WriteHelp writes the full help to w
+func (parser *Parser) WriteHelp(w io.Writer) {
+	fmt.Fprintln(w, parser.genHelp())
+}
+
+// PrintUsage writes the usage line to Output
+func (parser *Parser) PrintUsage() {
+	parser.WriteUsage(parser.output())
+}
+
+// PrintHelp writes the full help to Output
+func (parser *Parser) PrintHelp() {
+	parser.WriteHelp(parser.output())
+}
+
+// useColor reports whether help should be colored. Color is never used when
+// NO_COLOR is set or Output is not a terminal
+func (parser *Parser) useColor() bool {
+	if !parser.Color || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	f, ok := parser.output().(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
+}
+
+func (parser *Parser) bold(s string) string {
+	if !parser.useColor() {
+		return s
+	}
+	return "\x1b[1m" + s + "\x1b[0m"
+}
+
+func (parser *Parser) section(s string) string {
+	if !parser.useColor() {
+		return s
+	}
+	return "\x1b[1;33m" + s + "\x1b[0m"
+}
+
+// SetWidth forces help to be wrapped at cols columns, without clamping.
+// SetWidth(0) restores detecting the terminal width
+func (parser *Parser) SetWidth(cols int) *Parser {
+	parser.cols = cols
+	return parser
+}
+
+// minWidth and maxWidth bound the detected terminal width so that help
+// stays readable on very narrow and very wide terminals
+const (
+	minWidth = 40
+	maxWidth = 100
+)
+
+// width is the width help is wrapped at. Unless set with SetWidth, the
+// terminal width is detected on every call so that it is never stale, and
+// clamped to [minWidth, maxWidth]
+func (parser *Parser) width() int {
+	if parser.cols > 0 {
+		return parser.cols
+	}
+	return clampWidth(getTermWidth())
+}
+
+func clampWidth(cols int) int {
+	return min(max(cols, minWidth), maxWidth)
+}
+
+func (parser *Parser) prog() string {
+	if parser.Prog != "" {
+		return parser.Prog
+	} else if len(os.Args) == 0 {
+		return ""
+	}
+	return filepath.Base(os.Args[0])
+}
+
 func (parser *Parser) genHeader() string {
-	scriptName := parser.Summary
+	termWidth := parser.width()
+	textWidth := termWidth / 2
+	scriptName := parser.prog()
 	header := strings.Builder{}
-	header.WriteString("Usage: ")
+	header.WriteString(parser.section("Usage:"))
+	header.WriteString(" ")
 	header.WriteString(scriptName)
 	header.WriteString(" ")
-	scriptNameL := header.Len()
+	scriptNameL := len("Usage: ") + len(scriptName) + 1
 	ws := strings.Repeat(" ", scriptNameL)
 
 	if scriptNameL > termWidth {
@@ -522,7 +2868,11 @@ func (parser *Parser) genHeader() string {
 
 	totalLen := scriptNameL
 
-	for _, v := range argumentsSlice {
+	for _, v := range parser.argumentsSlice {
+		if v.opts.Hidden {
+			continue
+		}
+
 		h := v.genHeader()
 		hL := len(h)
 
@@ -530,17 +2880,21 @@ func (parser *Parser) genHeader() string {
 			totalLen = 0
 			header.WriteString("\n")
 			header.WriteString(ws)
-			header.WriteString(h)
+			header.WriteString(parser.bold(h))
 			totalLen += scriptNameL
 		} else {
-			header.WriteString(h)
+			header.WriteString(parser.bold(h))
 		}
 
 		header.WriteString(" ")
 		totalLen += hL + 1
 	}
 
-	for _, v := range keywordsMap {
+	for _, v := range parser.keywordsOrder {
+		if v.opts.Hidden {
+			continue
+		}
+
 		h := v.genHeader(false, false)
 		hL := len(h)
 
@@ -548,121 +2902,130 @@ func (parser *Parser) genHeader() string {
 			totalLen = 0
 			header.WriteString("\n")
 			header.WriteString(ws)
-			header.WriteString(h)
+			header.WriteString(parser.bold(h))
 			totalLen += scriptNameL
 		} else {
-			header.WriteString(h)
+			header.WriteString(parser.bold(h))
 		}
 
 		header.WriteString(" ")
 		totalLen += hL + 1
 	}
 
+	if len(parser.visibleSubcommands()) > 0 {
+		if totalLen+len("COMMAND ...") >= termWidth {
+			header.WriteString("\n")
+			header.WriteString(ws)
+		}
+		header.WriteString("COMMAND ...")
+	}
+
 	return header.String()
 }
 
-func (S *argument) genHelp() string {
+// genEntry renders an option header followed by its help text and example,
+// which are aligned to a column and wrapped at the terminal width
+func (parser *Parser) genEntry(header string, opts *Option) string {
 	res := strings.Builder{}
-	header := S.genHeader()
-	res.WriteString(header)
-	headerL := len(header)
-	r := textWidth / 3
-	ws := strings.Repeat(" ", r)
-	totalLen := r
-
-	if r <= headerL {
-		res.WriteString("\n")
-		res.WriteString(ws)
-	} else {
-		res.WriteString(strings.Repeat(" ", r-headerL))
-	}
+	res.WriteString(parser.bold(header))
 
-	for _, v := range strings.Split(S.opts.Help, " ") {
-		vL := len(v)
+	termWidth := parser.width()
+	r := termWidth / 2 / 3
+	ws := strings.Repeat(" ", r)
 
-		if totalLen >= termWidth || totalLen+vL >= termWidth {
-			totalLen = 0
+	if opts.Help != "" {
+		if r <= len(header) {
 			res.WriteString("\n")
 			res.WriteString(ws)
-			res.WriteString(v)
-			totalLen += r
 		} else {
-			res.WriteString(v)
+			res.WriteString(strings.Repeat(" ", r-len(header)))
 		}
-
-		res.WriteString(" ")
-		totalLen += vL + 1
+		res.WriteString(wrap(opts.Help, r, termWidth))
 	}
 
-	return res.String()
-}
-
-func (S *keyword) genHelp() string {
-	res := strings.Builder{}
-	header := S.genHeader(true, true)
-	res.WriteString(header)
-
-	r := textWidth / 3
-	ws := strings.Repeat(" ", r)
-	totalLen := r
-	headerL := len(header)
-
-	if r <= headerL {
+	if opts.Example != "" {
 		res.WriteString("\n")
 		res.WriteString(ws)
-	} else {
-		res.WriteString(strings.Repeat(" ", r-headerL))
+		res.WriteString(wrap("example: "+opts.Example, r, termWidth))
 	}
 
-	for _, v := range strings.Split(S.opts.Help, " ") {
-		vL := len(v)
-		if totalLen >= termWidth || totalLen+vL >= termWidth {
-			totalLen = 0
-			res.WriteString("\n")
-			res.WriteString(ws)
-			res.WriteString(v)
-			totalLen += r
-		} else {
-			res.WriteString(v)
-		}
-
-		res.WriteString(" ")
-		totalLen += vL + 1
+	if opts.Pattern != "" {
+		res.WriteString("\n")
+		res.WriteString(ws)
+		res.WriteString(wrap("pattern: "+opts.Pattern, r, termWidth))
 	}
 
 	return res.String()
 }
 
+func (S *argument) genHelp(parser *Parser) string {
+	return parser.genEntry(S.genHeader(), S.opts)
+}
+
+func (S *keyword) genHelp(parser *Parser) string {
+	return parser.genEntry(S.genHeader(true, true), S.opts)
+}
+
+func (S *subcommand) genHelp(parser *Parser) string {
+	x := &argument{
+		name: S.name,
+		opts: &Option{Metavar: S.name, Help: S.opts.Help},
+	}
+	return x.genHelp(parser)
+}
+
 func (parser *Parser) genHelp() string {
 	res := strings.Builder{}
 	res.WriteString(parser.genHeader())
 	res.WriteString("\n")
-	totalLen := 0
+	if parser.Summary != "" {
+		res.WriteString(parser.Summary)
+		res.WriteString("\n\n")
+	}
+	res.WriteString(wrap(parser.Help, 0, parser.width()))
 
-	for _, v := range strings.Split(parser.Help, " ") {
-		vL := len(v)
-		if totalLen >= termWidth || totalLen+vL >= termWidth {
-			totalLen = 0
+	writeGroup := func(group string) {
+		for _, v := range parser.argumentsSlice {
+			if v.opts.Group == group && !v.opts.Hidden {
+				res.WriteString(v.genHelp(parser))
+				res.WriteString("\n")
+			}
+		}
+
+		if group == "" {
+			res.WriteString("\n")
+			res.WriteString(parser.section("Keyword arguments:"))
 			res.WriteString("\n")
-			res.WriteString(v)
-		} else {
-			res.WriteString(v)
 		}
 
-		res.WriteString(" ")
-		totalLen += vL + 1
+		for _, v := range parser.keywordsOrder {
+			if v.opts.Group == group && !v.opts.Hidden {
+				res.WriteString(v.genHelp(parser))
+				res.WriteString("\n")
+			}
+		}
 	}
 
-	res.WriteString("\n\nArguments:\n")
-	for _, v := range argumentsMap {
-		res.WriteString(v.genHelp())
+	res.WriteString("\n\n")
+	res.WriteString(parser.section("Arguments:"))
+	res.WriteString("\n")
+	writeGroup("")
+
+	for _, group := range parser.groups {
 		res.WriteString("\n")
+		res.WriteString(parser.section(group + ":"))
+		res.WriteString("\n")
+		writeGroup(group)
 	}
 
-	res.WriteString("\nKeyword arguments:\n")
-	for _, v := range keywordsMap {
-		res.WriteString(v.genHelp())
+	if subcommands := parser.visibleSubcommands(); len(subcommands) > 0 {
+		res.WriteString("\n")
+		res.WriteString(parser.section("Commands:"))
 		res.WriteString("\n")
+		for _, v := range subcommands {
+			res.WriteString(v.genHelp(parser))
+			res.WriteString("\n")
+		}
 	}
 
 	return res.String()
@@ -720,7 +3083,7 @@ func main() {
 	// }
 
 	// fmt.Printf("%#v\n", res)
-	//fmt.Printf("%#v\n", argumentsMap["X"].genHeader())
-	// fmt.Printf("%s\n", keywordsMap["a-switch"].genHelp())
+	//fmt.Printf("%#v\n", parser.argumentsMap["X"].genHeader())
+	// fmt.Printf("%s\n", parser.keywordsMap["a-switch"].genHelp())
 	println(parser.genHelp())
 }
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, parser *Parser) map[string][]string {
+	t.Helper()
+	parsed, err := parser.ParseErr()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return parsed
+}
+
+func expectValues(t *testing.T, parsed map[string][]string, name string, want ...string) {
+	t.Helper()
+	if got := parsed[name]; !slices.Equal(got, want) {
+		t.Errorf("%s: got %q, want %q", name, got, want)
+	}
+}
+
+func TestPositionalDefaults(t *testing.T) {
+	parser := New([]string{"x"})
+	parser.Argument("x", &Option{Default: []string{"d"}})
+	parser.Argument("y", &Option{Default: []string{"dy"}})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "x", "x")
+	expectValues(t, parsed, "y", "dy")
+}
+
+func TestPositionalVariadic(t *testing.T) {
+	parser := New([]string{"1", "2", "3", "4"})
+	parser.Argument("a", &Option{})
+	parser.Argument("b", &Option{Nargs: "*"})
+	parser.Argument("c", &Option{})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "a", "1")
+	expectValues(t, parsed, "b", "2", "3")
+	expectValues(t, parsed, "c", "4")
+
+	parser = New([]string{"1"})
+	parser.Argument("a", &Option{Nargs: "?"})
+	parser.Argument("b", &Option{})
+
+	parsed = mustParse(t, parser)
+	expectValues(t, parsed, "a")
+	expectValues(t, parsed, "b", "1")
+}
+
+func TestPositionalMissing(t *testing.T) {
+	parser := New([]string{"one"})
+	parser.Argument("a", &Option{})
+	parser.Argument("b", &Option{})
+
+	_, err := parser.ParseErr()
+	if !errors.Is(err, ErrLessArgs) {
+		t.Fatalf("got %v, want ErrLessArgs", err)
+	}
+}
+
+func TestPositionalMissingNames(t *testing.T) {
+	for _, tc := range []struct {
+		nargs   string
+		missing string
+	}{
+		{"", "missing: b, c\n"},
+		{"+", "missing: a, c\n"},
+	} {
+		parser := New([]string{"one"})
+		parser.Argument("a", &Option{Nargs: tc.nargs})
+		parser.Argument("b", &Option{})
+		parser.Argument("c", &Option{})
+
+		_, err := parser.ParseErr()
+		if !errors.Is(err, ErrLessArgs) || !strings.Contains(err.Error(), tc.missing) {
+			t.Errorf("nargs %q: got %v, want %q", tc.nargs, err, tc.missing)
+		}
+	}
+}
+
+func TestSubcommandAfterSwitchValue(t *testing.T) {
+	parser := New([]string{"--name", "commit", "commit", "-m", "x"})
+	parser.Keyword("n", "name", &Option{N: 1})
+	commit := parser.Subcommand("commit", &Option{})
+	commit.Keyword("m", "message", &Option{N: 1})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "name", "commit")
+	expectValues(t, parsed, SubcommandKey, "commit")
+	expectValues(t, commit.Parsed, "message", "x")
+}
+
+func TestGreedyBeforeSubcommand(t *testing.T) {
+	parser := New([]string{"--exec", "git", "add"})
+	parser.Keyword("", "exec", &Option{Greedy: true})
+	parser.Subcommand("add", &Option{})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "exec", "git", "add")
+	if _, ok := parsed[SubcommandKey]; ok {
+		t.Errorf("got subcommand %q from greedy values", parsed[SubcommandKey])
+	}
+}
+
+func TestPosixStrict(t *testing.T) {
+	for _, tc := range []struct {
+		argv   []string
+		strict bool
+		args   []string
+		force  bool
+	}{
+		{[]string{"-v", "file", "--force", "x"}, false, []string{"file", "x"}, true},
+		{[]string{"-v", "file", "--force", "x"}, true, []string{"file", "--force", "x"}, false},
+		{[]string{"-n", "1", "file", "-f"}, false, []string{"file"}, true},
+		{[]string{"-n", "1", "file", "-f"}, true, []string{"file", "-f"}, false},
+		{[]string{"file", "-vf"}, false, []string{"file"}, true},
+		{[]string{"file", "-vf"}, true, []string{"file", "-vf"}, false},
+		{[]string{"file", "-la"}, true, []string{"file", "-la"}, false},
+		{[]string{"file", "--verb"}, true, []string{"file", "--verb"}, false},
+		{[]string{"-v", "--", "-f"}, true, []string{"-f"}, false},
+	} {
+		parser := New(tc.argv)
+		parser.PosixStrict = tc.strict
+		parser.AllowAbbrev = true
+		parser.Keyword("v", "verbose", &Option{})
+		parser.Keyword("f", "force", &Option{})
+		parser.Keyword("n", "num", &Option{N: 1})
+		parser.Argument("args", &Option{Nargs: "*"})
+
+		parsed, err := parser.ParseErr()
+		if err != nil {
+			t.Errorf("%q strict %v: unexpected error: %v", tc.argv, tc.strict, err)
+			continue
+		}
+		if got := parsed["args"]; !slices.Equal(got, tc.args) {
+			t.Errorf("%q strict %v: got args %q, want %q", tc.argv, tc.strict, got, tc.args)
+		}
+		if got := parser.IsSet("force"); got != tc.force {
+			t.Errorf("%q strict %v: got force %v, want %v", tc.argv, tc.strict, got, tc.force)
+		}
+	}
+}
+
+func TestResetRestoresArgv(t *testing.T) {
+	parser := New([]string{"-vq", "--exec", "rm", "-rf", "x", "--", "y"})
+	parser.Keyword("v", "verbose", &Option{})
+	parser.Keyword("q", "quiet", &Option{})
+	parser.Keyword("", "exec", &Option{Greedy: true})
+
+	for i := 0; i < 2; i++ {
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "exec", "rm", "-rf", "x")
+		if !parser.IsSet("verbose") || !parser.IsSet("quiet") {
+			t.Errorf("run %d: bundled switches not set", i)
+		}
+		if rest := parser.Rest(); !slices.Equal(rest, []string{"y"}) {
+			t.Errorf("run %d: got rest %q", i, rest)
+		}
+		parser.Reset()
+	}
+
+	parser = New([]string{"-v", "add", "f"})
+	parser.Keyword("v", "verbose", &Option{})
+	add := parser.Subcommand("add", &Option{})
+	add.Argument("file", &Option{})
+
+	for i := 0; i < 2; i++ {
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, SubcommandKey, "add")
+		expectValues(t, add.Parsed, "file", "f")
+		parser.Reset()
+	}
+}
+
+func TestSwitchBoolValue(t *testing.T) {
+	for _, tc := range []struct {
+		argv []string
+		set  bool
+	}{
+		{[]string{"--verbose=true"}, true},
+		{[]string{"--verbose=false"}, false},
+		{[]string{"-v=false"}, false},
+		{[]string{"-qv=false"}, false},
+		{[]string{"--verbose", "--verbose=false"}, false},
+		{[]string{"--verbose=false", "--verbose"}, true},
+	} {
+		parser := New(tc.argv)
+		parser.Keyword("v", "verbose", &Option{Flag: true, AllowDuplicates: true})
+		parser.Keyword("q", "quiet", &Option{Excludes: []string{"verbose"}})
+
+		parsed := mustParse(t, parser)
+		if _, ok := parsed["verbose"]; ok != tc.set || parser.IsSet("verbose") != tc.set {
+			t.Errorf("%q: got verbose %q, want set %v", tc.argv, parsed["verbose"], tc.set)
+		}
+		if b, err := parser.GetBool("verbose"); err != nil || b != tc.set {
+			t.Errorf("%q: GetBool got %v, %v, want %v", tc.argv, b, err, tc.set)
+		}
+		if v := parser.Value("verbose"); v == nil {
+			t.Errorf("%q: Value got nil", tc.argv)
+		}
+	}
+
+	parser := New([]string{"--json=false", "--yaml"})
+	parser.Keyword("", "json", &Option{})
+	parser.Keyword("", "yaml", &Option{})
+	parser.AtMostOneOf("json", "yaml")
+	mustParse(t, parser)
+
+	parser = New([]string{"--no-color=false"})
+	parser.Keyword("", "color", &Option{Negatable: true})
+	expectValues(t, mustParse(t, parser), "color", "true")
+
+	parser = New([]string{"--verbose=maybe"})
+	parser.Keyword("", "verbose", &Option{})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("got %v, want ErrInvalidValue", err)
+	}
+}
+
+func TestSwitchOffOverridesFallbacks(t *testing.T) {
+	t.Setenv("TEST_VERBOSE", "1")
+	parser := New([]string{"--verbose=false"})
+	parser.Keyword("v", "verbose", &Option{EnvVar: "TEST_VERBOSE"})
+
+	if parsed := mustParse(t, parser); parser.IsSet("verbose") || parsed["verbose"] != nil {
+		t.Errorf("got verbose %q, want unset", parsed["verbose"])
+	}
+
+	var opts struct {
+		Color bool `arg:"-c" default:"true"`
+	}
+	parser = New([]string{"-c=false"})
+	if err := parser.Bind(&opts); err != nil {
+		t.Fatal(err)
+	}
+	mustParse(t, parser)
+	if opts.Color {
+		t.Errorf("got color true, want false")
+	}
+}
+
+func setStdin(t *testing.T, contents string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		f.Close()
+	})
+}
+
+func TestStdinFirstDash(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		setStdin(t, "input")
+		parser := New([]string{"--a", "-", "--b", "-"})
+		parser.Keyword("", "b", &Option{N: 1, AllowStdin: true})
+		parser.Keyword("", "a", &Option{N: 1, AllowStdin: true})
+
+		_, err := parser.ParseErr()
+		if !errors.Is(err, ErrStdin) || !strings.Contains(err.Error(), "b [keyword]") {
+			t.Fatalf("got %v, want ErrStdin for b", err)
+		}
+	}
+
+	setStdin(t, "")
+	parser := New([]string{"-"})
+	parser.Argument("input", &Option{AllowStdin: true})
+	expectValues(t, mustParse(t, parser), "input", "")
+}
+
+func TestKeywordsAreCopies(t *testing.T) {
+	parser := New([]string{})
+	parser.Keyword("", "color", &Option{N: 1, Enum: []string{"auto", "never"}, Default: []string{"auto"}})
+	parser.Argument("file", &Option{Requires: []string{"color"}})
+
+	keywords := parser.Keywords()
+	keywords[1].Enum[0] = "zzz"
+	keywords[1].Default[0] = "zzz"
+	parser.Arguments()[0].Requires[0] = "zzz"
+
+	opts := parser.options("color")
+	if opts.Enum[0] != "auto" || opts.Default[0] != "auto" {
+		t.Errorf("registered options changed: %q %q", opts.Enum, opts.Default)
+	}
+	if parser.options("file").Requires[0] != "color" {
+		t.Errorf("registered requires changed: %q", parser.options("file").Requires)
+	}
+}
+
+func TestNargsPlusPositions(t *testing.T) {
+	for _, argv := range [][]string{
+		{"--files", "a", "b", "c", "--verbose"},
+		{"--verbose", "--files", "a", "b", "c"},
+		{"--files=a", "b", "c", "--verbose"},
+		{"--verbose", "--files=a", "b", "c"},
+		{"--files", "a", "--files", "b", "c", "--verbose"},
+		{"--verbose", "--files", "a", "--files", "b", "c"},
+	} {
+		parser := New(argv)
+		parser.Keyword("f", "files", &Option{Nargs: "+", AllowDuplicates: true})
+		parser.Keyword("v", "verbose", &Option{})
+
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "files", "a", "b", "c")
+		expectValues(t, parsed, "verbose")
+	}
+
+	for _, argv := range [][]string{
+		{"--files", "--verbose"},
+		{"--verbose", "--files"},
+	} {
+		parser := New(argv)
+		parser.Keyword("f", "files", &Option{Nargs: "+"})
+		parser.Keyword("v", "verbose", &Option{})
+		if _, err := parser.ParseErr(); !errors.Is(err, ErrLessArgs) {
+			t.Errorf("%q: got %v, want ErrLessArgs", argv, err)
+		}
+	}
+}
+
+func TestBundleTakesNextArg(t *testing.T) {
+	for _, argv := range [][]string{
+		{"-czf", "out.tar", "src"},
+		{"-czfout.tar", "src"},
+		{"-cz", "-f", "out.tar", "src"},
+	} {
+		parser := New(argv)
+		parser.Keyword("c", "create", &Option{})
+		parser.Keyword("z", "gzip", &Option{})
+		parser.Keyword("f", "file", &Option{N: 1})
+		parser.Argument("src", &Option{})
+
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "file", "out.tar")
+		expectValues(t, parsed, "src", "src")
+		if !parser.IsSet("create") || !parser.IsSet("gzip") {
+			t.Errorf("%q: bundled switches not set", argv)
+		}
+	}
+}
+
+func TestInterspersedPositionals(t *testing.T) {
+	for _, tc := range []struct {
+		argv  []string
+		files []string
+		num   []string
+	}{
+		{[]string{"file1", "--verbose", "file2"}, []string{"file1", "file2"}, nil},
+		{[]string{"--verbose", "file1", "file2"}, []string{"file1", "file2"}, nil},
+		{[]string{"file1", "-n", "3", "file2", "--verbose", "file3"}, []string{"file1", "file2", "file3"}, []string{"3"}},
+		{[]string{"a", "--verbose", "b", "--", "c"}, []string{"a", "b", "c"}, nil},
+	} {
+		parser := New(tc.argv)
+		parser.Keyword("v", "verbose", &Option{})
+		parser.Keyword("n", "num", &Option{N: 1})
+		parser.Argument("files", &Option{Nargs: "+"})
+
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "files", tc.files...)
+		expectValues(t, parsed, "num", tc.num...)
+	}
+}
+
+func TestDoubleDashIsLiteral(t *testing.T) {
+	parser := New([]string{"cmd", "--", "prog", "--flag", "--", "more"})
+	parser.Keyword("", "flag", &Option{})
+	parser.Argument("command", &Option{})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "command", "cmd")
+	if parser.IsSet("flag") {
+		t.Errorf("--flag after -- was matched as a switch")
+	}
+	if rest := parser.Rest(); !slices.Equal(rest, []string{"prog", "--flag", "--", "more"}) {
+		t.Errorf("got rest %q", rest)
+	}
+
+	parser = New([]string{"--", "prog", "--flag", "--", "more"})
+	parser.Keyword("", "flag", &Option{})
+	parser.Argument("command", &Option{})
+
+	parsed = mustParse(t, parser)
+	expectValues(t, parsed, "command", "prog")
+	if rest := parser.Rest(); !slices.Equal(rest, []string{"--flag", "--", "more"}) {
+		t.Errorf("got rest %q", rest)
+	}
+}
+
+func TestShortAttachedValue(t *testing.T) {
+	for _, tc := range []struct {
+		argv    []string
+		num     []string
+		all     bool
+		verbose bool
+	}{
+		{[]string{"-n5"}, []string{"5"}, false, false},
+		{[]string{"-n", "5"}, []string{"5"}, false, false},
+		{[]string{"-n=5"}, []string{"5"}, false, false},
+		{[]string{"-n-5"}, []string{"-5"}, false, false},
+		{[]string{"-av"}, nil, true, true},
+		{[]string{"-avn5"}, []string{"5"}, true, true},
+		{[]string{"-avn", "5"}, []string{"5"}, true, true},
+	} {
+		parser := New(tc.argv)
+		parser.Keyword("a", "all", &Option{})
+		parser.Keyword("v", "verbose", &Option{})
+		parser.Keyword("n", "num", &Option{N: 1})
+
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "num", tc.num...)
+		if parser.IsSet("all") != tc.all || parser.IsSet("verbose") != tc.verbose {
+			t.Errorf("%q: got all %v verbose %v", tc.argv, parser.IsSet("all"), parser.IsSet("verbose"))
+		}
+	}
+
+	parser := New([]string{"-ax"})
+	parser.Keyword("a", "all", &Option{})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("got %v, want ErrInvalidBundle", err)
+	}
+}
+
+func TestDuplicateSwitchesAccumulate(t *testing.T) {
+	for _, argv := range [][]string{
+		{"-a", "1", "-a", "2"},
+		{"-a", "1", "-b", "x", "-a", "2"},
+		{"-a", "1", "-b", "x", "-a=2", "-b", "y"},
+		{"-b", "x", "-a1", "-b", "y", "-a", "2"},
+	} {
+		parser := New(argv)
+		parser.Keyword("a", "", &Option{N: 1, AllowDuplicates: true})
+		parser.Keyword("b", "", &Option{Nargs: "*", AllowDuplicates: true})
+
+		parsed := mustParse(t, parser)
+		expectValues(t, parsed, "a", "1", "2")
+		for _, v := range parsed["b"] {
+			if v != "x" && v != "y" {
+				t.Errorf("%q: value %q leaked into b", argv, v)
+			}
+		}
+	}
+
+	parser := New([]string{"-a", "1", "-a", "2"})
+	parser.Keyword("a", "", &Option{N: 1})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("got %v, want ErrDuplicate", err)
+	}
+}
+
+func TestNargsValidation(t *testing.T) {
+	for _, nargs := range []string{"+*", "++", "?*", "+?+", "x"} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, ErrInvalidNargs) {
+					t.Errorf("nargs %q: got %v, want ErrInvalidNargs", nargs, err)
+				}
+			}()
+			New([]string{}).Keyword("", "files", &Option{Nargs: nargs})
+		}()
+	}
+
+	for _, nargs := range []string{"+", "*", "?"} {
+		New([]string{}).Keyword("", "files", &Option{Nargs: nargs})
+		New([]string{}).Argument("files", &Option{Nargs: nargs})
+	}
+}
+
+func TestEmptyEnvIsUnset(t *testing.T) {
+	t.Setenv("T_NAME", "")
+	t.Setenv("T_PREFIX", "")
+	parser := New([]string{})
+	parser.EnvPrefix = "T"
+	parser.Keyword("", "name", &Option{N: 1, Default: []string{"bob"}})
+	parser.Keyword("", "prefix", &Option{N: 1, AllowEmpty: true})
+
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "name", "bob")
+	expectValues(t, parsed, "prefix", "")
+
+	parser = New([]string{"--name", ""})
+	parser.Keyword("", "name", &Option{N: 1})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrEmptyValue) {
+		t.Errorf("got %v, want ErrEmptyValue", err)
+	}
+}
+
+func TestEmptyVariadicIsNotGiven(t *testing.T) {
+	newParser := func(argv ...string) *Parser {
+		parser := New(append([]string{}, argv...))
+		parser.Keyword("", "all", &Option{Excludes: []string{"files"}})
+		parser.Keyword("", "url", &Option{N: 1})
+		parser.Argument("files", &Option{Nargs: "*"})
+		return parser
+	}
+
+	parser := newParser("--all")
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "files")
+
+	parser = newParser()
+	parser.Keyword("", "sync", &Option{Requires: []string{"files"}})
+	parser.SetArgv([]string{"--sync"})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrMissingDeps) {
+		t.Errorf("Requires: got %v, want ErrMissingDeps", err)
+	}
+
+	parser = newParser().RequireOneOf("url", "files")
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrGroupConstraint) {
+		t.Errorf("RequireOneOf: got %v, want ErrGroupConstraint", err)
+	}
+
+	parser = newParser("a.txt").RequireOneOf("url", "files")
+	mustParse(t, parser)
+}
+
+func TestOneOfGroupsWithVariadic(t *testing.T) {
+	for _, tc := range []struct {
+		argv []string
+		err  error
+	}{
+		{[]string{}, ErrGroupConstraint},
+		{[]string{"--url", "u"}, nil},
+		{[]string{"a", "b"}, nil},
+		{[]string{"--url", "u", "a"}, ErrGroupConstraint},
+	} {
+		parser := New(tc.argv)
+		parser.Keyword("", "url", &Option{N: 1})
+		parser.Argument("files", &Option{Nargs: "*"})
+		parser.RequireOneOf("url", "files").AtMostOneOf("url", "files")
+
+		if _, err := parser.ParseErr(); !errors.Is(err, tc.err) {
+			t.Errorf("%q: got %v, want %v", tc.argv, err, tc.err)
+		}
+	}
+}
+
+func TestKeywordNameConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		register func(parser *Parser)
+	}{
+		{"no- after negatable", func(parser *Parser) {
+			parser.Keyword("", "color", &Option{Negatable: true})
+			parser.Keyword("", "no-color", &Option{})
+		}},
+		{"negatable after no-", func(parser *Parser) {
+			parser.Keyword("", "no-color", &Option{})
+			parser.Keyword("", "color", &Option{Negatable: true})
+		}},
+		{"long after short", func(parser *Parser) {
+			parser.SingleDashLong = true
+			parser.Keyword("a", "all", &Option{})
+			parser.Keyword("", "a", &Option{})
+		}},
+		{"short after long", func(parser *Parser) {
+			parser.SingleDashLong = true
+			parser.Keyword("", "a", &Option{})
+			parser.Keyword("a", "all", &Option{})
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, ErrNameConflict) {
+					t.Errorf("got %v, want ErrNameConflict", err)
+				}
+			}()
+			tc.register(New([]string{}))
+		})
+	}
+
+	parser := New([]string{"-a"})
+	parser.Keyword("a", "all", &Option{})
+	parser.Keyword("", "no-all", &Option{})
+	parsed := mustParse(t, parser)
+	expectValues(t, parsed, "all")
+}
+
+func TestErrorPositions(t *testing.T) {
+	for _, tc := range []struct {
+		argv []string
+		want string
+	}{
+		{[]string{"--format", "csv"}, "position: 1\n"},
+		{[]string{"-vq", "--format", "csv"}, "position: 2\n"},
+		{[]string{"-vq", "-fcsv"}, "position: 1\n"},
+		{[]string{"--zz", "--format", "csv"}, "position: 2\n"},
+		{[]string{"-v", "--", "--format", "csv"}, "position: 2\n"},
+		{[]string{"-vq", "sub", "-vq", "--format", "csv"}, "position: 4\n"},
+		{[]string{"-q", "sub", "--zz", "-v", "--format", "csv"}, "position: 5\n"},
+		{[]string{"-q", "--zz", "sub", "--format=csv"}, "position: 3\n"},
+	} {
+		parser := New(tc.argv)
+		parser.AllowUnknown = true
+		parser.Keyword("v", "verbose", &Option{})
+		parser.Keyword("q", "quiet", &Option{})
+		parser.Keyword("f", "format", &Option{N: 1, Enum: []string{"xml", "json"}})
+		sub := parser.Subcommand("sub", &Option{})
+		sub.AllowUnknown = true
+		sub.Keyword("v", "verbose", &Option{})
+		sub.Keyword("q", "quiet", &Option{})
+		sub.Keyword("f", "format", &Option{N: 1, Enum: []string{"xml", "json"}})
+		if slices.Contains(tc.argv, "--") {
+			parser.Argument("rest", &Option{Nargs: "*", Enum: []string{"xml", "json"}})
+		}
+
+		_, err := parser.ParseErr()
+		if !errors.Is(err, ErrInvalidChoice) || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got %v, want ErrInvalidChoice at %q", tc.argv, err, tc.want)
+		}
+	}
+
+	parser := New([]string{"-vq", "sub", "-vq", "--zz"})
+	parser.Keyword("v", "verbose", &Option{})
+	parser.Keyword("q", "quiet", &Option{})
+	parser.Subcommand("sub", &Option{}).Keyword("v", "verbose", &Option{}).Keyword("q", "quiet", &Option{})
+	if _, err := parser.ParseErr(); !errors.Is(err, ErrUnknownFlag) || !strings.Contains(err.Error(), "position: 3\n") {
+		t.Errorf("got %v, want ErrUnknownFlag at position 3", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+// maxSuggestDistance is the largest edit distance a suggestion can be from
+// what was typed
+const maxSuggestDistance = 2
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	prev := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(x); i++ {
+		cur := make([]int, len(y)+1)
+		cur[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(y)]
+}
+
+// suggest returns the candidate closest to s, or "" if none is within
+// maxSuggestDistance
+func suggest(s string, candidates []string) string {
+	res := ""
+	best := maxSuggestDistance + 1
+	for _, x := range candidates {
+		if d := levenshtein(s, x); d < best {
+			res = x
+			best = d
+		}
+	}
+	return res
+}